@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// mustLoadConfig loads the settings, exiting if they are not valid
+func mustLoadConfig(flags *pflag.FlagSet) *config {
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		fields := log.Fields{
+			"event": "INVALID_CONFIG",
+			"err":   err,
+		}
+		if invalid, ok := err.(*configError); ok {
+			fields["problems"] = invalid.Problems
+		}
+		log.WithFields(fields).Fatal("The configuration was not valid.")
+	}
+	cfg.applyLogLevel()
+	return cfg
+}
+
+// signalContext returns a context which is cancelled on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(quit)
+	}()
+	return ctx, cancel
+}
+
+// runOnce writes the configuration a single time, returning the exit code
+func runOnce(args []string) int {
+	flags := pflag.NewFlagSet("once", pflag.ExitOnError)
+	defineFlags(flags)
+	_ = flags.Parse(args)
+	cfg := mustLoadConfig(flags)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if _, err := cfg.bizOps(nil).Run(ctx); err != nil {
+		log.WithFields(log.Fields{
+			"event": "ERROR_CONFIGURATION_WRITE",
+			"err":   err,
+		}).Error("Failed to write the configuration.")
+		return 1
+	}
+	return 0
+}
+
+// dryRunBlocked the exit code of a dry run whose configuration the blast radius guard would refuse to write
+const dryRunBlocked = 3
+
+// dryRun prints the configuration, or how it differs from the existing file, without writing it.
+// It is printed even when the blast radius guard would block the write, which the exit code signals.
+func dryRun(args []string, out io.Writer) int {
+	flags := pflag.NewFlagSet("dry-run", pflag.ExitOnError)
+	defineFlags(flags)
+	showDiff := flags.Bool("diff", false, "Print the changes to the targets against the existing file instead of the configuration.")
+	_ = flags.Parse(args)
+	cfg := mustLoadConfig(flags)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	bizOps := cfg.bizOps(nil)
+	bizOps.DryRun = true
+
+	result, err := bizOps.Run(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event": "ERROR_CONFIGURATION_DRY_RUN",
+			"err":   err,
+		}).Error("Failed to generate the configuration.")
+		return 1
+	}
+
+	if *showDiff {
+		diff, err := json.MarshalIndent(result.Diff, "", "  ")
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "ERROR_CONFIGURATION_DRY_RUN",
+				"err":   err,
+			}).Error("Failed to encode the diff.")
+			return 1
+		}
+		fmt.Fprintln(out, string(diff))
+	} else {
+		// printed in the configured format, so it is what would be written
+		fmt.Fprintln(out, strings.TrimSuffix(string(result.Configuration), "\n"))
+	}
+
+	if result.Blocked != nil {
+		log.WithFields(log.Fields{
+			"event": "CONFIGURATION_DRY_RUN_BLOCKED",
+			"err":   result.Blocked,
+		}).Error("The blast radius guard would refuse to write this configuration.")
+		return dryRunBlocked
+	}
+	return 0
+}
+
+// validate lints the configuration, by default the file in the output directory, returning the exit code
+func validate(args []string, out io.Writer) int {
+	flags := pflag.NewFlagSet("validate", pflag.ExitOnError)
+	directory := flags.StringP("directory", "d", "/etc/prometheus", "The directory containing the configuration, used if no file is given.")
+	format := flags.String("format", "json", "The format of the configuration: json, yaml, probe or scrapeconfig. A given file is read by its extension unless this is set.")
+	_ = flags.Parse(args)
+
+	encoder, err := encoderFor(*format)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	path := filepath.Join(*directory, strings.TrimSuffix(servicediscovery.Filename, filepath.Ext(servicediscovery.Filename))+encoder.Extension())
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+		if !flags.Changed("format") {
+			encoder = servicediscovery.EncoderForFile(path)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %v\n", path, err)
+		return 1
+	}
+
+	problems := servicediscovery.ValidateEncodedConfiguration(content, encoder)
+	for _, problem := range problems {
+		fmt.Fprintf(out, "%s: %v\n", path, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Fprintf(out, "%s: valid\n", path)
+	return 0
+}
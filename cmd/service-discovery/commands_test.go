@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeBizOps() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data": {"Healthchecks": [
+			{"code": "one", "url": "https://one.ft.com/__health", "isLive": true, "monitors": [{"code": "system-one"}]},
+			{"code": "two", "url": "https://two.ft.com/__health", "isLive": false, "monitors": [{"code": "system-two"}]}
+		]}}`)
+	}))
+}
+
+func TestDryRun(t *testing.T) {
+	bizOps := fakeBizOps()
+	defer bizOps.Close()
+
+	testCases := map[string]struct {
+		existing     string
+		args         []string
+		expectedOut  string
+		expectedCode int
+	}{
+		"the configuration should be printed": {
+			expectedOut: `[
+  {
+    "targets": [
+      "https://two.ft.com/__health"
+    ],
+    "labels": {
+      "observe": "no",
+      "system": "system-two"
+    }
+  },
+  {
+    "targets": [
+      "https://one.ft.com/__health"
+    ],
+    "labels": {
+      "observe": "yes",
+      "system": "system-one"
+    }
+  }
+]
+`,
+		},
+		"the configuration should be printed when the guard would block it": {
+			existing: `[{"targets": ["https://one.ft.com/__health", "https://three.ft.com/__health", "https://four.ft.com/__health", "https://five.ft.com/__health", "https://six.ft.com/__health"], "labels": {"observe": "yes"}}]`,
+			expectedOut: `[
+  {
+    "targets": [
+      "https://two.ft.com/__health"
+    ],
+    "labels": {
+      "observe": "no",
+      "system": "system-two"
+    }
+  },
+  {
+    "targets": [
+      "https://one.ft.com/__health"
+    ],
+    "labels": {
+      "observe": "yes",
+      "system": "system-one"
+    }
+  }
+]
+`,
+			expectedCode: dryRunBlocked,
+		},
+		"the configuration should be printed in the configured format": {
+			args: []string{"--format", "yaml"},
+			expectedOut: `- targets:
+  - https://two.ft.com/__health
+  labels:
+    observe: "no"
+    system: system-two
+- targets:
+  - https://one.ft.com/__health
+  labels:
+    observe: "yes"
+    system: system-one
+`,
+		},
+		"the diff against the existing file should be printed": {
+			existing: `[{"targets": ["https://one.ft.com/__health"], "labels": {"observe": "yes", "system": "system-one"}}]`,
+			args:     []string{"--diff"},
+			expectedOut: `{
+  "added": [
+    {
+      "target": "https://two.ft.com/__health",
+      "labels": [
+        {
+          "observe": "no",
+          "system": "system-two"
+        }
+      ]
+    }
+  ],
+  "removed": [],
+  "relabelled": []
+}
+`,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "dry-run-test-")
+			require.NoError(t, err)
+			defer os.RemoveAll(directory)
+
+			path := filepath.Join(directory, servicediscovery.Filename)
+			if test.existing != "" {
+				require.NoError(t, ioutil.WriteFile(path, []byte(test.existing), 0644))
+			}
+
+			args := append([]string{
+				"--directory", directory,
+				"--biz-ops-base-url", bizOps.URL,
+				"--biz-ops-api-key", "api-key",
+				"--biz-ops-max-attempts", "1",
+			}, test.args...)
+			out := &bytes.Buffer{}
+
+			assert.Equal(t, test.expectedCode, dryRun(args, out), "Exit code was not as expected")
+			assert.Equal(t, test.expectedOut, out.String(), "Output was not as expected")
+
+			content, _ := ioutil.ReadFile(path)
+			assert.Equal(t, test.existing, string(content), "The existing file should not have been written")
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := map[string]struct {
+		content      string
+		format       string
+		expectedCode int
+		expectedOut  string
+	}{
+		"valid file should pass": {
+			content:      `[{"targets": ["https://one.ft.com/__health"], "labels": {"observe": "yes"}}]`,
+			expectedCode: 0,
+			expectedOut:  "%s: valid\n",
+		},
+		"invalid file should fail": {
+			content:      `[{"targets": [], "labels": {}}]`,
+			expectedCode: 1,
+			expectedOut:  "%s: group 0 has no targets\n",
+		},
+		"valid yaml file should pass": {
+			content:      "- targets:\n  - https://one.ft.com/__health\n  labels:\n    observe: \"yes\"\n",
+			format:       "yaml",
+			expectedCode: 0,
+			expectedOut:  "%s: valid\n",
+		},
+		"probe file should pass": {
+			content:      "---\napiVersion: monitoring.coreos.com/v1\nkind: Probe\nmetadata:\n  name: system-one\nspec:\n  targets:\n    staticConfig:\n      static:\n      - https://one.ft.com/__health\n      labels:\n        observe: \"yes\"\n",
+			format:       "probe",
+			expectedCode: 0,
+			expectedOut:  "%s: valid\n",
+		},
+		"yaml file with unknown fields should fail": {
+			content:      "- targets:\n  - https://one.ft.com/__health\n  label:\n    observe: \"yes\"\n",
+			format:       "yaml",
+			expectedCode: 1,
+			expectedOut:  "%s: not a list of target groups (yaml: unmarshal errors:\n  line 3: field label not found in type servicediscovery.prometheusConfiguration)\n",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "validate-test-")
+			require.NoError(t, err)
+			defer os.RemoveAll(directory)
+
+			if test.format == "" {
+				test.format = "json"
+			}
+			encoder, err := encoderFor(test.format)
+			require.NoError(t, err)
+			path := filepath.Join(directory, "health-check-service-discovery"+encoder.Extension())
+			require.NoError(t, ioutil.WriteFile(path, []byte(test.content), 0644))
+			out := &bytes.Buffer{}
+
+			assert.Equal(t, test.expectedCode, validate([]string{"--directory", directory, "--format", test.format}, out), "Exit code was not as expected")
+			assert.Equal(t, fmt.Sprintf(test.expectedOut, path), out.String(), "Output was not as expected")
+		})
+	}
+}
+
+func TestValidateFileInTheGivenFormat(t *testing.T) {
+	directory, err := ioutil.TempDir("", "validate-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "resources.yaml")
+	content := "---\napiVersion: monitoring.coreos.com/v1alpha1\nkind: ScrapeConfig\nmetadata:\n  name: system-one\nspec:\n  staticConfigs:\n  - targets:\n    - https://one.ft.com/__health\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	out := &bytes.Buffer{}
+	assert.Equal(t, 0, validate([]string{"--format", "scrapeconfig", path}, out), "Exit code was not as expected")
+	assert.Equal(t, path+": valid\n", out.String(), "Output was not as expected")
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/api"
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// config the settings read from the flags, environment and optional config file
+type config struct {
+	// File the config file the settings were read from, if any
+	File                       string
+	Port                       int
+	Directory                  string
+	Tick                       time.Duration
+	Verbose                    bool
+	BizOpsBaseURL              string
+	BizOpsAPIKey               string
+	Retry                      api.RetryPolicy
+	PageSize                   int
+	PageConcurrency            int
+	AllowPartialData           bool
+	LabelMappings              []servicediscovery.LabelMapping
+	Filters                    []servicediscovery.FilterRule
+	Guard                      servicediscovery.BlastRadiusGuard
+	HealthMaxTicksSinceSuccess int
+	RefreshAPIKey              string
+	StaticTargetFiles          []string
+	FileSDSources              []string
+	OverridesFile              string
+	SnapshotFile               string
+	Shards                     int
+	Partition                  *servicediscovery.Partitioning
+	Encoder                    servicediscovery.Encoder
+	Exporter                   *servicediscovery.ProbeExporter
+}
+
+// configError lists every problem found validating the configuration
+type configError struct {
+	Problems []string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, "; "))
+}
+
+func defineFlags(flags *pflag.FlagSet) {
+	flags.StringP("config", "c", "", "A YAML file of settings using the same keys as the flags, reloaded on SIGHUP or when it changes.")
+	flags.IntP("port", "p", 8080, "The port to run the prometheus metrics server on.")
+	flags.StringP("directory", "d", "/etc/prometheus", "The directory configuration will be written to.")
+	flags.DurationP("tick", "t", time.Duration(60)*time.Second, "Duration between background refreshes of the configuration.")
+	flags.BoolP("verbose", "v", false, "Enable more detailed logging.")
+	flags.String("biz-ops-base-url", "https://api.ft.com/biz-ops", "The base url for the biz-ops API.")
+	flags.String("biz-ops-api-key", "", "The API key to access the biz-ops API")
+	flags.Int("biz-ops-max-attempts", 3, "The maximum number of attempts made for each biz-ops request.")
+	flags.Duration("biz-ops-retry-base-delay", 500*time.Millisecond, "The delay before the first retry of a failed biz-ops request, doubled for each subsequent retry.")
+	flags.Duration("biz-ops-retry-max-delay", 10*time.Second, "The maximum delay between retries of a failed biz-ops request.")
+	flags.Float64("biz-ops-retry-jitter", 0.2, "The fraction (0-1) of each retry delay which is randomised.")
+	flags.Int("biz-ops-page-size", 500, "The number of healthchecks fetched per biz-ops request, 0 fetches them all in a single request.")
+	flags.Int("biz-ops-page-concurrency", 4, "The maximum number of pages of healthchecks fetched from biz-ops at once.")
+	flags.StringArray("label-mapping", nil, "Map a biz-ops healthcheck field to a label, in the form label=field.path[|transform] (transforms: lower, upper, yesno). Defaults to system=monitors.code and observe=isLive|yesno.")
+	flags.StringArray("filter", nil, "Include or exclude healthchecks, in the form include|exclude:field=pattern where field is system, host, live or label:<name>. Rules are applied in order, repeat the flag for each rule.")
+	flags.Float64("max-target-drop-percent", 50, "Refuse to write a configuration which removes more than this percentage of the existing targets, 0 disables the check.")
+	flags.Int("max-target-drop-count", 0, "Refuse to write a configuration which removes more than this number of the existing targets, 0 disables the check.")
+	flags.Bool("force-write", false, "Write the configuration even if it removes more targets than allowed.")
+	flags.Int("health-max-ticks-since-success", 3, "The number of ticks without a successful run before the service is reported unhealthy.")
+	flags.String("refresh-api-key", "", "The API key required to trigger a run with POST /refresh, the endpoint is disabled if unset.")
+	flags.Bool("allow-partial-data", false, "Write the healthchecks biz-ops returned when the response also contained GraphQL errors.")
+	flags.StringSlice("static-targets", nil, "A YAML file of targets to add to those in biz-ops, each with a url and labels.")
+	flags.StringSlice("file-sd-source", nil, "A Prometheus file_sd JSON file whose targets are added to those in biz-ops.")
+	flags.String("snapshot-file", "", "Where the healthchecks from the last successful fetch are kept, to serve the targets after a restart while biz-ops is unavailable. Defaults to .biz-ops-snapshot in the output directory.")
+	flags.String("format", "json", "The format of the written configuration: json or yaml for file_sd, or probe or scrapeconfig for Prometheus Operator resources. The filename extension follows the format.")
+	flags.String("kubernetes-namespace", "", "The namespace of the Prometheus Operator resources, left to kubectl if unset.")
+	flags.String("kubernetes-name-prefix", "biz-ops", "The prefix of the name of each Prometheus Operator resource.")
+	flags.String("probe-prober-url", "", "The address of the prober, such as blackbox exporter, the Probe resources use.")
+	flags.String("probe-module", "http_2xx", "The prober module the Probe resources use.")
+	flags.String("exporter-address", "", "Write every target as this exporter, such as prometheus-health-check-exporter.in.ft.com, with the healthcheck url in the __param_endpoint and instance labels, so Prometheus needs no relabel_configs.")
+	flags.String("exporter-scheme", "https", "The scheme the exporter is scraped with, written as __scheme__.")
+	flags.String("exporter-metrics-path", "/scrape", "The path the exporter is scraped at, written as __metrics_path__.")
+	flags.String("exporter-module", "", "The module passed to the exporter as __param_module, such as http_2xx for blackbox exporter.")
+	flags.Int("shards", 0, "Split the targets across this many files, one per Prometheus replica, by a stable hash of their URL. 0 or 1 writes a single file.")
+	flags.String("partition-label", "", "Write a file for each value of this label, such as team, instead of a single file.")
+	flags.String("partition-filename", servicediscovery.DefaultPartitionFilenameTemplate, "The name of each partition's file, where {value} is replaced by the label value.")
+	flags.String("partition-unmatched", servicediscovery.DefaultUnmatchedPartition, "The partition for targets without the partition label.")
+	flags.String("overrides-file", "", "A YAML file of targets to exclude, add or relabel, read on every run. Defaults to overrides.yaml in the output directory.")
+}
+
+// loadConfig reads and validates the settings. Flags take precedence over
+// environment variables, which take precedence over the config file.
+func loadConfig(flags *pflag.FlagSet) (*config, error) {
+	v := viper.New()
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	if err := v.BindPFlags(flags); err != nil {
+		return nil, err
+	}
+
+	if path := v.GetString("config"); path != "" {
+		v.SetConfigFile(path)
+		v.SetConfigType("yaml")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("could not read the config file %s (%v)", path, err)
+		}
+	}
+
+	return parseConfig(v)
+}
+
+// encoderFor returns the encoder for the format, with the Prometheus Operator resources left to be configured
+func encoderFor(format string) (servicediscovery.Encoder, error) {
+	switch strings.ToLower(format) {
+	case "probe":
+		return servicediscovery.KubernetesEncoder{Kind: servicediscovery.KindProbe}, nil
+	case "scrapeconfig":
+		return servicediscovery.KubernetesEncoder{Kind: servicediscovery.KindScrapeConfig}, nil
+	}
+	encoder, err := servicediscovery.EncoderFor(format)
+	if err != nil {
+		return nil, fmt.Errorf("unknown output format %q, must be json, yaml, probe or scrapeconfig", format)
+	}
+	return encoder, nil
+}
+
+func parseConfig(v *viper.Viper) (*config, error) {
+	var problems []string
+
+	cfg := &config{
+		File:          v.GetString("config"),
+		Port:          v.GetInt("port"),
+		Directory:     v.GetString("directory"),
+		Tick:          v.GetDuration("tick"),
+		Verbose:       v.GetBool("verbose"),
+		BizOpsBaseURL: v.GetString("biz-ops-base-url"),
+		BizOpsAPIKey:  v.GetString("biz-ops-api-key"),
+		Retry: api.RetryPolicy{
+			MaxAttempts: v.GetInt("biz-ops-max-attempts"),
+			BaseDelay:   v.GetDuration("biz-ops-retry-base-delay"),
+			MaxDelay:    v.GetDuration("biz-ops-retry-max-delay"),
+			Jitter:      v.GetFloat64("biz-ops-retry-jitter"),
+		},
+		PageSize:         v.GetInt("biz-ops-page-size"),
+		PageConcurrency:  v.GetInt("biz-ops-page-concurrency"),
+		AllowPartialData: v.GetBool("allow-partial-data"),
+		Guard: servicediscovery.BlastRadiusGuard{
+			MaxDropPercent: v.GetFloat64("max-target-drop-percent"),
+			MaxDropCount:   v.GetInt("max-target-drop-count"),
+			Force:          v.GetBool("force-write"),
+		},
+		HealthMaxTicksSinceSuccess: v.GetInt("health-max-ticks-since-success"),
+		RefreshAPIKey:              v.GetString("refresh-api-key"),
+		StaticTargetFiles:          v.GetStringSlice("static-targets"),
+		FileSDSources:              v.GetStringSlice("file-sd-source"),
+		OverridesFile:              v.GetString("overrides-file"),
+		SnapshotFile:               v.GetString("snapshot-file"),
+		Shards:                     v.GetInt("shards"),
+	}
+	if cfg.SnapshotFile == "" {
+		cfg.SnapshotFile = filepath.Join(cfg.Directory, servicediscovery.SnapshotFilename)
+	}
+	if cfg.OverridesFile == "" {
+		cfg.OverridesFile = filepath.Join(cfg.Directory, servicediscovery.OverridesFilename)
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d is not a valid port", cfg.Port))
+	}
+	if cfg.Directory == "" {
+		problems = append(problems, "directory must be set")
+	}
+	if cfg.Tick <= 0 {
+		problems = append(problems, fmt.Sprintf("tick %s must be positive", cfg.Tick))
+	}
+	if _, err := url.ParseRequestURI(cfg.BizOpsBaseURL); err != nil {
+		problems = append(problems, fmt.Sprintf("biz-ops-base-url %q is not a valid url", cfg.BizOpsBaseURL))
+	}
+	if cfg.BizOpsAPIKey == "" {
+		problems = append(problems, "biz-ops-api-key must be set")
+	}
+	if cfg.Retry.Jitter < 0 || cfg.Retry.Jitter > 1 {
+		problems = append(problems, fmt.Sprintf("biz-ops-retry-jitter %v must be between 0 and 1", cfg.Retry.Jitter))
+	}
+	if cfg.PageSize < 0 {
+		problems = append(problems, fmt.Sprintf("biz-ops-page-size %d must not be negative", cfg.PageSize))
+	}
+	encoder, err := encoderFor(v.GetString("format"))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("format: %v", err))
+	}
+	if kubernetes, ok := encoder.(servicediscovery.KubernetesEncoder); ok {
+		kubernetes.Namespace = v.GetString("kubernetes-namespace")
+		kubernetes.NamePrefix = v.GetString("kubernetes-name-prefix")
+		kubernetes.ProberURL = v.GetString("probe-prober-url")
+		kubernetes.Module = v.GetString("probe-module")
+		if err := kubernetes.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("format: %v", err))
+		}
+		encoder = kubernetes
+	}
+	cfg.Encoder = encoder
+	if address := v.GetString("exporter-address"); address != "" {
+		cfg.Exporter = &servicediscovery.ProbeExporter{
+			Address:     address,
+			Scheme:      v.GetString("exporter-scheme"),
+			MetricsPath: v.GetString("exporter-metrics-path"),
+			Module:      v.GetString("exporter-module"),
+		}
+		if err := cfg.Exporter.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("exporter: %v", err))
+		}
+		if encoder, ok := cfg.Encoder.(servicediscovery.KubernetesEncoder); ok && encoder.Kind == servicediscovery.KindProbe {
+			problems = append(problems, "exporter-address cannot be used with the probe format, which uses probe-prober-url")
+		}
+	}
+	// a ScrapeConfig's static targets are scraped as they are, so they must be rewritten to go through the exporter
+	if encoder, ok := cfg.Encoder.(servicediscovery.KubernetesEncoder); ok && encoder.Kind == servicediscovery.KindScrapeConfig && cfg.Exporter == nil {
+		problems = append(problems, "the scrapeconfig format needs exporter-address, as the healthchecks can't be scraped directly")
+	}
+	if cfg.Shards < 0 {
+		problems = append(problems, fmt.Sprintf("shards %d must not be negative", cfg.Shards))
+	}
+	if label := v.GetString("partition-label"); label != "" {
+		cfg.Partition = &servicediscovery.Partitioning{
+			Label:            label,
+			FilenameTemplate: v.GetString("partition-filename"),
+			Unmatched:        v.GetString("partition-unmatched"),
+		}
+		if err := cfg.Partition.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("partition: %v", err))
+		}
+		if cfg.Shards > 1 {
+			problems = append(problems, "shards and partition-label cannot be used together")
+		}
+	}
+	if cfg.HealthMaxTicksSinceSuccess < 1 {
+		problems = append(problems, fmt.Sprintf("health-max-ticks-since-success %d must be at least 1", cfg.HealthMaxTicksSinceSuccess))
+	}
+
+	for _, item := range listSetting(v, "label-mapping") {
+		mapping, err := parseLabelMapping(item)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("label-mapping: %v", err))
+			continue
+		}
+		cfg.LabelMappings = append(cfg.LabelMappings, mapping)
+	}
+
+	for _, item := range listSetting(v, "filter") {
+		rule, err := parseFilterRule(item)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("filter: %v", err))
+			continue
+		}
+		cfg.Filters = append(cfg.Filters, rule)
+	}
+
+	if len(problems) > 0 {
+		return nil, &configError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// listSetting returns the items of a list setting, which is a list in the config file,
+// a repeated flag or a space separated env var. The items may contain commas, such as
+// filter patterns, so a flag's items aren't split on them.
+func listSetting(v *viper.Viper, key string) []interface{} {
+	items := make([]interface{}, 0)
+	switch value := v.Get(key).(type) {
+	case []interface{}:
+		return value
+	case string:
+		// viper reads a repeated flag as the flag's own "[a,b]" form, which quotes any item containing a comma
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			values, err := csv.NewReader(strings.NewReader(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))).Read()
+			if err != nil && err != io.EOF {
+				return append(items, value)
+			}
+			for _, value := range values {
+				items = append(items, value)
+			}
+			return items
+		}
+	}
+	for _, value := range v.GetStringSlice(key) {
+		items = append(items, value)
+	}
+	return items
+}
+
+// parseLabelMapping accepts either the flag form or a map with label, field and transform keys
+func parseLabelMapping(item interface{}) (servicediscovery.LabelMapping, error) {
+	if value, ok := item.(string); ok {
+		return servicediscovery.ParseLabelMapping(value)
+	}
+	fields, err := cast.ToStringMapStringE(item)
+	if err != nil {
+		return servicediscovery.LabelMapping{}, fmt.Errorf("%v is not a label mapping", item)
+	}
+	mapping := servicediscovery.LabelMapping{
+		Label:     fields["label"],
+		Field:     fields["field"],
+		Transform: fields["transform"],
+	}
+	return mapping, mapping.Validate()
+}
+
+// parseFilterRule accepts either the flag form or a map with action, field and pattern keys
+func parseFilterRule(item interface{}) (servicediscovery.FilterRule, error) {
+	if value, ok := item.(string); ok {
+		return servicediscovery.ParseFilterRule(value)
+	}
+	fields, err := cast.ToStringMapStringE(item)
+	if err != nil {
+		return servicediscovery.FilterRule{}, fmt.Errorf("%v is not a filter rule", item)
+	}
+	return servicediscovery.ParseFilterRule(fmt.Sprintf("%s:%s=%s", fields["action"], fields["field"], fields["pattern"]))
+}
+
+// bizOps builds the service discovery for the settings
+func (cfg *config) bizOps(targets *servicediscovery.TargetStore) *servicediscovery.BizOps {
+	// biz-ops takes precedence, then the static targets, then the file_sd files
+	var sources []servicediscovery.Source
+	for _, path := range cfg.StaticTargetFiles {
+		sources = append(sources, servicediscovery.NewStaticFileSource(path, nil))
+	}
+	for _, path := range cfg.FileSDSources {
+		sources = append(sources, servicediscovery.NewFileSDSource(path, nil))
+	}
+
+	return &servicediscovery.BizOps{
+		Writer: servicediscovery.NewFileWriter(cfg.Directory, nil),
+		ApiClient: &api.BizOpsClient{
+			Client: http.Client{
+				Timeout: 10 * time.Second,
+			},
+			APIKey:  cfg.BizOpsAPIKey,
+			BaseUrl: cfg.BizOpsBaseURL,
+			Retry:   cfg.Retry,
+		},
+		AllowPartialData: cfg.AllowPartialData,
+		PageSize:         cfg.PageSize,
+		PageConcurrency:  cfg.PageConcurrency,
+		LabelMappings:    cfg.LabelMappings,
+		Filters:          cfg.Filters,
+		Guard:            cfg.Guard,
+		Targets:          targets,
+		Sources:          sources,
+		Overrides:        servicediscovery.NewOverridesFile(cfg.OverridesFile, nil),
+		Snapshot:         servicediscovery.NewSnapshotFile(cfg.SnapshotFile, nil),
+		Shards:           cfg.Shards,
+		Partition:        cfg.Partition,
+		Encoder:          cfg.Encoder,
+		Exporter:         cfg.Exporter,
+	}
+}
+
+func (cfg *config) applyLogLevel() {
+	if cfg.Verbose {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+}
+
+// liveConfig holds the settings in use, which are swapped atomically when the config is reloaded
+type liveConfig struct {
+	flags   *pflag.FlagSet
+	current atomic.Value
+	// reloading serialises reloads triggered by SIGHUP and file changes
+	reloading sync.Mutex
+	// Changed receives a value after each successful reload
+	Changed chan struct{}
+}
+
+func newLiveConfig(flags *pflag.FlagSet, initial *config) *liveConfig {
+	live := &liveConfig{
+		flags:   flags,
+		Changed: make(chan struct{}, 1),
+	}
+	live.current.Store(initial)
+	return live
+}
+
+// Get returns the settings currently in use
+func (live *liveConfig) Get() *config {
+	return live.current.Load().(*config)
+}
+
+// Reload reads the settings again, keeping those in use if they are not valid
+func (live *liveConfig) Reload() error {
+	live.reloading.Lock()
+	defer live.reloading.Unlock()
+
+	next, err := loadConfig(live.flags)
+	if err != nil {
+		fields := log.Fields{
+			"event": "CONFIG_RELOAD_FAILED",
+			"err":   err,
+		}
+		if invalid, ok := err.(*configError); ok {
+			fields["problems"] = invalid.Problems
+		}
+		log.WithFields(fields).Error("The configuration could not be reloaded, the previous configuration is still in use.")
+		return err
+	}
+
+	previous := live.Get()
+	if next.Port != previous.Port {
+		log.WithFields(log.Fields{
+			"event":   "CONFIG_RELOAD_PORT_IGNORED",
+			"port":    previous.Port,
+			"newPort": next.Port,
+		}).Warn("The port can not be changed without a restart.")
+		next.Port = previous.Port
+	}
+
+	live.current.Store(next)
+	next.applyLogLevel()
+	select {
+	case live.Changed <- struct{}{}:
+	default:
+	}
+
+	log.WithFields(log.Fields{
+		"event":     "CONFIG_RELOADED",
+		"directory": next.Directory,
+		"tick":      next.Tick.Seconds(),
+		"verbose":   next.Verbose,
+	}).Info("The configuration has been reloaded.")
+	return nil
+}
+
+// Watch reloads the settings whenever the config file changes
+func (live *liveConfig) Watch(path string) {
+	watcher := viper.New()
+	watcher.SetConfigFile(path)
+	watcher.SetConfigType("yaml")
+	watcher.OnConfigChange(func(event fsnotify.Event) {
+		log.WithFields(log.Fields{
+			"event": "CONFIG_FILE_CHANGED",
+			"file":  event.Name,
+		}).Info("The config file changed.")
+		_ = live.Reload()
+	})
+	watcher.WatchConfig()
+}
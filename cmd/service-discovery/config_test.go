@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, directory string, content string) string {
+	path := filepath.Join(directory, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func testFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("service-discovery", pflag.ContinueOnError)
+	defineFlags(flags)
+	require.NoError(t, flags.Parse(args))
+	return flags
+}
+
+func TestLoadConfig(t *testing.T) {
+	testCases := map[string]struct {
+		file             string
+		args             []string
+		expectedTick     time.Duration
+		expectedMappings []servicediscovery.LabelMapping
+		expectedFilters  []string
+		expectedProblems []string
+	}{
+		"settings should be read from the file": {
+			file: `
+biz-ops-api-key: file-key
+tick: 30s
+label-mapping:
+  - system=monitors.code
+  - label: team
+    field: monitors.team.code
+    transform: lower
+filter:
+  - exclude:live=false
+  - action: include
+    field: label:team
+    pattern: team-a,team-b
+`,
+			expectedTick: 30 * time.Second,
+			expectedMappings: []servicediscovery.LabelMapping{
+				{Label: "system", Field: "monitors.code"},
+				{Label: "team", Field: "monitors.team.code", Transform: "lower"},
+			},
+			expectedFilters: []string{"exclude:live=false", "include:label:team=team-a,team-b"},
+		},
+		"flags should take precedence over the file": {
+			file:         "biz-ops-api-key: file-key\ntick: 30s\n",
+			args:         []string{"--tick", "5s"},
+			expectedTick: 5 * time.Second,
+		},
+		"filter flags should keep the commas in their patterns": {
+			file: "biz-ops-api-key: file-key\ntick: 30s\n",
+			args: []string{
+				"--filter", "include:label:team=team-a,team-b",
+				"--filter", `exclude:host=^legacy-[0-9]{1,3}\.ft\.com$`,
+				"--label-mapping", "system=monitors.code",
+			},
+			expectedTick:     30 * time.Second,
+			expectedMappings: []servicediscovery.LabelMapping{{Label: "system", Field: "monitors.code"}},
+			expectedFilters:  []string{"include:label:team=team-a,team-b", `exclude:host=^legacy-[0-9]{1,3}\.ft\.com$`},
+		},
+		"every problem should be reported": {
+			file: `
+tick: 0s
+biz-ops-base-url: not a url
+label-mapping:
+  - label: __bad
+    field: code
+filter:
+  - action: drop
+    field: system
+    pattern: a
+`,
+			expectedProblems: []string{
+				"tick 0s must be positive",
+				`biz-ops-base-url "not a url" is not a valid url`,
+				"biz-ops-api-key must be set",
+				`label-mapping: "__bad" is not a valid prometheus label name`,
+				`filter: filter rule "drop:system=a" must start with include or exclude`,
+			},
+		},
+		"probes should need a prober": {
+			file:             "biz-ops-api-key: file-key\nformat: probe\n",
+			expectedProblems: []string{"format: a prober url is needed to write Probes"},
+		},
+		"the exporter should not be combined with probes": {
+			file: "biz-ops-api-key: file-key\nformat: probe\nprobe-prober-url: blackbox:9115\nexporter-address: exporter.ft.com\nexporter-scheme: ftp\n",
+			expectedProblems: []string{
+				`exporter: the exporter scheme "ftp" must be http or https`,
+				"exporter-address cannot be used with the probe format, which uses probe-prober-url",
+			},
+		},
+		"scrape configs should need the exporter": {
+			file:             "biz-ops-api-key: file-key\nformat: scrapeconfig\n",
+			expectedProblems: []string{"the scrapeconfig format needs exporter-address, as the healthchecks can't be scraped directly"},
+		},
+		"partitions should not be combined with shards": {
+			file: "biz-ops-api-key: file-key\nshards: 2\npartition-label: team\npartition-filename: targets.json\n",
+			expectedProblems: []string{
+				`partition: partition filename "targets.json" must contain {value} once`,
+				"shards and partition-label cannot be used together",
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "config-test-")
+			require.NoError(t, err)
+			defer os.RemoveAll(directory)
+
+			path := writeConfigFile(t, directory, test.file)
+			cfg, err := loadConfig(testFlags(t, append([]string{"--config", path}, test.args...)...))
+
+			if test.expectedProblems != nil {
+				require.IsType(t, &configError{}, err)
+				assert.Equal(t, test.expectedProblems, err.(*configError).Problems, "Problems were not as expected")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedTick, cfg.Tick, "Tick was not as expected")
+			assert.Equal(t, path, cfg.File, "File was not as expected")
+			assert.Equal(t, test.expectedMappings, cfg.LabelMappings, "Label mappings were not as expected")
+			filters := make([]string, 0)
+			for _, rule := range cfg.Filters {
+				filters = append(filters, rule.String())
+			}
+			if test.expectedFilters == nil {
+				test.expectedFilters = []string{}
+			}
+			assert.Equal(t, test.expectedFilters, filters, "Filters were not as expected")
+		})
+	}
+}
+
+func TestLiveConfigReload(t *testing.T) {
+	directory, err := ioutil.TempDir("", "config-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	path := writeConfigFile(t, directory, "biz-ops-api-key: file-key\ntick: 30s\nport: 8080\n")
+	flags := testFlags(t, "--config", path)
+	initial, err := loadConfig(flags)
+	require.NoError(t, err)
+	live := newLiveConfig(flags, initial)
+
+	writeConfigFile(t, directory, "biz-ops-api-key: file-key\ntick: 10s\nport: 9090\n")
+	require.NoError(t, live.Reload())
+	assert.Equal(t, 10*time.Second, live.Get().Tick, "Tick should have been reloaded")
+	assert.Equal(t, 8080, live.Get().Port, "Port should not change without a restart")
+	select {
+	case <-live.Changed:
+	default:
+		t.Error("A successful reload should signal the change")
+	}
+
+	writeConfigFile(t, directory, "tick: -1s\n")
+	assert.Error(t, live.Reload(), "An invalid config should fail to reload")
+	assert.Equal(t, 10*time.Second, live.Get().Tick, "The previous config should be kept when a reload fails")
+	assert.Equal(t, "file-key", live.Get().BizOpsAPIKey, "The previous config should be kept when a reload fails")
+	select {
+	case <-live.Changed:
+		t.Error("A failed reload should not signal a change")
+	default:
+	}
+}
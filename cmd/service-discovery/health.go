@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/server"
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+)
+
+const systemCode = "prometheus-biz-ops-service-discovery"
+
+// set at build time with -ldflags "-X main.revision=..."
+var (
+	revision    = "unknown"
+	buildNumber = "unknown"
+	buildDate   = "unknown"
+)
+
+const panicGuide = "https://runbooks.in.ft.com/" + systemCode
+
+// health the checks for the service, which read the settings on each check so they follow config reloads
+func health(status *servicediscovery.RunStatus, settings func() *config) server.Health {
+	return server.Health{
+		SystemCode:  systemCode,
+		Name:        "Prometheus Biz-Ops Service Discovery",
+		Description: "Writes the healthchecks in Biz-Ops as Prometheus service discovery configuration.",
+		Checks: []server.Check{
+			{
+				ID:               "biz-ops-reachable",
+				Name:             "Biz-Ops was reachable on the last attempt",
+				Severity:         2,
+				BusinessImpact:   "Changes to healthchecks in Biz-Ops will not be picked up by Prometheus.",
+				TechnicalSummary: "The last attempt to fetch the healthchecks from the Biz-Ops API failed.",
+				PanicGuide:       panicGuide,
+				Checker: func() (string, error) {
+					lastRun, _ := status.LastRun()
+					if lastRun.IsZero() {
+						return "", errors.New("no attempt has been made to fetch the healthchecks yet")
+					}
+					if err := status.LastFetchErr(); err != nil {
+						return "", fmt.Errorf("fetching the healthchecks failed at %s: %v", lastRun.UTC().Format(time.RFC3339), err)
+					}
+					return fmt.Sprintf("the healthchecks were fetched at %s", lastRun.UTC().Format(time.RFC3339)), nil
+				},
+			},
+			{
+				ID:               "configuration-up-to-date",
+				Name:             "The service discovery configuration has been written recently",
+				Severity:         1,
+				BusinessImpact:   "Prometheus may be scraping healthchecks which no longer exist, or missing new ones, so alerts may not fire.",
+				TechnicalSummary: "No service discovery run has succeeded within the configured number of ticks.",
+				PanicGuide:       panicGuide,
+				Checker: func() (string, error) {
+					lastSuccess := status.LastSuccess()
+					if lastSuccess.IsZero() {
+						return "", errors.New("no service discovery run has succeeded yet")
+					}
+					cfg := settings()
+					age := time.Since(lastSuccess)
+					if age > time.Duration(cfg.HealthMaxTicksSinceSuccess)*cfg.Tick {
+						return "", fmt.Errorf("the last successful run was %s ago", age.Round(time.Second))
+					}
+					return fmt.Sprintf("the last successful run was %s ago", age.Round(time.Second)), nil
+				},
+			},
+			{
+				ID:               "directory-writable",
+				Name:             "The output directory is writable",
+				Severity:         1,
+				BusinessImpact:   "Changes to healthchecks in Biz-Ops will not be picked up by Prometheus.",
+				TechnicalSummary: "The service discovery configuration cannot be written to the output directory, which is usually an EFS mount.",
+				PanicGuide:       panicGuide,
+				GTG:              true,
+				Checker: cached(time.Minute, func() (string, error) {
+					directory := settings().Directory
+					file, err := ioutil.TempFile(directory, ".health-check-")
+					if err != nil {
+						return "", fmt.Errorf("%s is not writable: %v", directory, err)
+					}
+					file.Close()
+					os.Remove(file.Name())
+					return fmt.Sprintf("%s is writable", directory), nil
+				}),
+			},
+		},
+	}
+}
+
+// cached runs the checker at most once per interval, so a health endpoint polled often
+// doesn't touch the output volume on every request
+func cached(interval time.Duration, checker func() (string, error)) func() (string, error) {
+	var mutex sync.Mutex
+	var checkedAt time.Time
+	var output string
+	var err error
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if checkedAt.IsZero() || time.Since(checkedAt) >= interval {
+			output, err = checker()
+			checkedAt = time.Now()
+		}
+		return output, err
+	}
+}
+
+func about() server.About {
+	about := server.About{
+		SystemCode:  systemCode,
+		Name:        "Prometheus Biz-Ops Service Discovery",
+		Purpose:     "Writes the healthchecks in Biz-Ops as Prometheus service discovery configuration.",
+		ServiceTier: "platinum",
+	}
+	about.BuildInfo.Revision = revision
+	about.BuildInfo.BuildNumber = buildNumber
+	about.BuildInfo.BuildDate = buildDate
+	return about
+}
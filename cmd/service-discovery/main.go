@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -18,92 +17,104 @@ import (
 	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
-	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-var serviceDiscoveryCount = prometheus.NewCounter(
-	prometheus.CounterOpts{
-		Name: "service_discovery_writes_total",
-		Help: "Number of service discovery file writes",
-	},
-)
-
-var serviceDiscoveryFailuresCount = prometheus.NewCounter(
-	prometheus.CounterOpts{
-		Name: "service_discovery_failures_total",
-		Help: "Number of service discovery failures",
-	},
-)
-
-var (
-	directory        string
-	tick             time.Duration
-	verbose          bool
-	port             int
-	bizOpsAPIBaseUrl string
-	bizOpsAPIKey     string
-)
+func doServiceDiscovery(ctx context.Context, bizopsDiscovery *servicediscovery.BizOps, status *servicediscovery.RunStatus) (servicediscovery.Result, error) {
+	result, err := bizopsDiscovery.Run(ctx)
+	if err != nil && ctx.Err() != nil {
+		log.WithFields(log.Fields{
+			"event": "CONFIGURATION_WRITE_CANCELLED",
+			"err":   err,
+		}).Info("Service discovery was cancelled before the configuration was written.")
+		return result, err
+	}
 
-func doServiceDiscovery(bizopsDiscovery *servicediscovery.BizOps) {
-	if err := bizopsDiscovery.Write(); err != nil {
+	status.Record(err)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"event": "ERROR_CONFIGURATION_WRITE",
 			"err":   err,
 		}).Error("Failed to write the configuration.")
-		serviceDiscoveryFailuresCount.Inc()
 	}
-	serviceDiscoveryCount.Inc()
+	return result, err
 }
 
 func main() {
-
-	viper.AutomaticEnv()
-	replacer := strings.NewReplacer("-", "_")
-	viper.SetEnvKeyReplacer(replacer)
-
-	pflag.IntP("port", "p", 8080, "The port to run the prometheus metrics server on.")
-	pflag.StringP("directory", "d", "/etc/prometheus", "The directory configuration will be written to.")
-	pflag.DurationP("tick", "t", time.Duration(60)*time.Second, "Duration between background refreshes of the configuration.")
-	pflag.BoolP("verbose", "v", false, "Enable more detailed logging.")
-	pflag.String("biz-ops-base-url", "https://api.ft.com/biz-ops", "The base url for the biz-ops API.")
-	pflag.String("biz-ops-api-key", "", "The API key to access the biz-ops API")
-	pflag.Parse()
-
-	viper.BindPFlags(pflag.CommandLine)
-
-	directory = viper.GetString("directory")
-	tick = viper.GetDuration("tick")
-	port = viper.GetInt("port")
-	listenAddress := fmt.Sprintf(":%d", port)
-
-	if viper.GetBool("verbose") {
-		log.SetLevel(log.DebugLevel)
-	}
-
 	if !terminal.IsTerminal(int(os.Stdout.Fd())) {
 		log.SetFormatter(&log.JSONFormatter{})
 	}
 
-	bizOpsAPIBaseUrl = viper.GetString("biz-ops-base-url")
-
-	if _, err := url.ParseRequestURI(bizOpsAPIBaseUrl); err != nil {
-		log.WithFields(log.Fields{
-			"event": "INVALID_ENV_VAR",
-			"value": bizOpsAPIBaseUrl,
-		}).Fatal("The BIZ_OPS_BASE_URL config value was not a valid url.")
+	// run is the default so the daemon can still be started with just flags
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	bizOpsAPIKey = viper.GetString("biz-ops-api-key")
-	if !viper.IsSet("biz-ops-api-key") || bizOpsAPIKey == "" {
-		log.WithFields(log.Fields{
-			"event": "MISSING_ENV_VAR",
-		}).Fatal("The BIZ_OPS_API_KEY environment variable must be set.")
+	switch command {
+	case "run":
+		runDaemon(args)
+	case "once":
+		os.Exit(runOnce(args))
+	case "dry-run":
+		os.Exit(dryRun(args, os.Stdout))
+	case "validate":
+		os.Exit(validate(args, os.Stdout))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected run, once, dry-run or validate\n", command)
+		os.Exit(2)
 	}
+}
+
+// runDaemon writes the configuration every tick, serving metrics and the targets until stopped
+func runDaemon(args []string) {
+	flags := pflag.NewFlagSet("run", pflag.ExitOnError)
+	defineFlags(flags)
+	_ = flags.Parse(args)
 
-	server := server.Server(listenAddress)
+	cfg := mustLoadConfig(flags)
+	live := newLiveConfig(flags, cfg)
+	listenAddress := fmt.Sprintf(":%d", cfg.Port)
+
+	targets := servicediscovery.NewTargetStore()
+	status := servicediscovery.NewRunStatus()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	api.RegisterMetrics(registry)
+	servicediscovery.RegisterMetrics(registry)
+	registry.MustRegister(servicediscovery.NewDataAgeGauge(targets))
 
 	done := make(chan bool)
+	discoveryCtx, cancelDiscovery := context.WithCancel(context.Background())
+	discoveryStopped := make(chan struct{})
+
+	// the background ticker and manual refreshes share one run at a time, each using the settings current when it starts
+	runner := servicediscovery.NewRunner(discoveryCtx, func(ctx context.Context) (servicediscovery.Result, error) {
+		return doServiceDiscovery(ctx, live.Get().bizOps(targets), status)
+	})
+
+	server := server.Server(listenAddress, server.Options{
+		Metrics:       registry,
+		Targets:       targets,
+		Health:        health(status, live.Get),
+		About:         about(),
+		Refresh:       runner.Run,
+		RefreshAPIKey: func() string { return live.Get().RefreshAPIKey },
+	})
+
+	if cfg.File != "" {
+		live.Watch(cfg.File)
+	}
+
+	go func() {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		for range reload {
+			_ = live.Reload()
+		}
+	}()
 
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -113,6 +124,20 @@ func main() {
 
 		<-quit
 
+		// stop any in-flight discovery and give it the grace period to finish
+		cancelDiscovery()
+		discoveryCtx, cancelDiscoveryWait := context.WithTimeout(context.Background(), 5*time.Second)
+		select {
+		case <-discoveryStopped:
+		case <-discoveryCtx.Done():
+			log.WithFields(log.Fields{
+				"event": "ERROR_STOPPING_DISCOVERY",
+				"err":   discoveryCtx.Err(),
+			}).Warn("Service discovery did not stop within the grace period.")
+		}
+		cancelDiscoveryWait()
+
+		// the server gets its own grace period so a slow run doesn't cut short the in-flight requests
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
@@ -142,32 +167,37 @@ func main() {
 	}()
 
 	go func() {
-		prometheus.MustRegister(serviceDiscoveryCount)
-		prometheus.MustRegister(serviceDiscoveryFailuresCount)
-
-		bizopsDiscovery := servicediscovery.BizOps{
-			Writer: servicediscovery.NewFileWriter(directory, nil),
-			ApiClient: &api.BizOpsClient{
-				Client: http.Client{
-					Timeout: 10 * time.Second,
-				},
-				APIKey:  bizOpsAPIKey,
-				BaseUrl: bizOpsAPIBaseUrl,
-			},
-		}
+		defer close(discoveryStopped)
+		defer runner.Wait()
 
 		log.WithFields(log.Fields{
 			"event":     "STARTED",
-			"port":      port,
-			"directory": directory,
-			"tick":      tick.Seconds(),
-			"verbose":   verbose,
+			"port":      cfg.Port,
+			"directory": cfg.Directory,
+			"tick":      cfg.Tick.Seconds(),
+			"verbose":   cfg.Verbose,
 		}).Info("Biz-Ops service discovery is running.")
 
-		doServiceDiscovery(&bizopsDiscovery)
-
-		for range time.NewTicker(tick).C {
-			doServiceDiscovery(&bizopsDiscovery)
+		// serve the last known good targets straight away in case biz-ops is unavailable
+		live.Get().bizOps(targets).WarmStart(discoveryCtx)
+		_, _ = runner.Run(discoveryCtx)
+
+		tick := cfg.Tick
+		ticker := time.NewTicker(tick)
+		defer func() { ticker.Stop() }()
+		for {
+			select {
+			case <-discoveryCtx.Done():
+				return
+			case <-live.Changed:
+				if next := live.Get().Tick; next != tick {
+					tick = next
+					ticker.Stop()
+					ticker = time.NewTicker(tick)
+				}
+			case <-ticker.C:
+				_, _ = runner.Run(discoveryCtx)
+			}
 		}
 	}()
 
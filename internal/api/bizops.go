@@ -2,28 +2,105 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type BizOpsClient struct {
 	Client  http.Client
 	APIKey  string
 	BaseUrl string
+	Retry   RetryPolicy
+}
+
+// Variables the values for the variables declared by a GraphQL query
+type Variables map[string]interface{}
+
+// Request the standard GraphQL request envelope sent to biz-ops
+type Request struct {
+	Query         string    `json:"query"`
+	Variables     Variables `json:"variables,omitempty"`
+	OperationName string    `json:"operationName,omitempty"`
 }
 
 type APIGatewayResponse struct {
 	Message string `json:"error"`
 }
 
-// Query takes a graphQL query string and unmarshals the response into the given response struct
+// GraphQLErrorLocation the position in the query a GraphQL error relates to
+type GraphQLErrorLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLErrorDetail a single entry from the errors array of a GraphQL response
+type GraphQLErrorDetail struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Locations  []GraphQLErrorLocation `json:"locations,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLError is returned when biz-ops responds with a non-empty errors array.
+// PartialData is true when the response also carried data, in which case the
+// response struct given to Query has been populated with it.
+type GraphQLError struct {
+	Errors      []GraphQLErrorDetail
+	PartialData bool
+}
+
+func (e *GraphQLError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, detail := range e.Errors {
+		message := detail.Message
+		if len(detail.Path) > 0 {
+			message = fmt.Sprintf("%s (path: %v)", message, detail.Path)
+		}
+		messages = append(messages, message)
+	}
+	kind := "without data"
+	if e.PartialData {
+		kind = "with partial data"
+	}
+	return fmt.Sprintf("biz-ops returned %d graphql error(s) %s: %s", len(e.Errors), kind, strings.Join(messages, "; "))
+}
+
+type graphQLEnvelope struct {
+	Data   json.RawMessage      `json:"data"`
+	Errors []GraphQLErrorDetail `json:"errors"`
+}
+
+func (envelope graphQLEnvelope) hasData() bool {
+	data := bytes.TrimSpace(envelope.Data)
+	return len(data) > 0 && !bytes.Equal(data, []byte("null"))
+}
+
+// Query takes a graphQL query string and unmarshals the response into the given response struct.
+// A *GraphQLError is returned if the response contains GraphQL errors.
 func (client *BizOpsClient) Query(query string, response interface{}) error {
-	payload := map[string]string{"query": query}
-	encodedPayload, err := json.Marshal(payload)
+	return client.QueryContext(context.Background(), query, nil, response)
+}
+
+// QueryContext behaves like Query, sending the given variables alongside the query.
+func (client *BizOpsClient) QueryContext(ctx context.Context, query string, vars Variables, response interface{}) error {
+	return client.Execute(ctx, Request{Query: query, Variables: vars}, response)
+}
+
+// Execute sends the given GraphQL request and unmarshals the response into the given response struct.
+// Transient failures are retried according to the client's Retry policy until
+// the context is cancelled.
+func (client *BizOpsClient) Execute(ctx context.Context, request Request, response interface{}) error {
+	encodedPayload, err := json.Marshal(request)
 	if err != nil {
 		return fmt.Errorf("invalid biz-ops request body (%v)", err)
 	}
@@ -33,23 +110,79 @@ func (client *BizOpsClient) Query(query string, response interface{}) error {
 		return fmt.Errorf("invalid biz-ops base url (%v)", err)
 	}
 	bizOpsUrl.Path = path.Join(bizOpsUrl.Path, "graphql")
-	req, err := http.NewRequest(http.MethodPost, bizOpsUrl.String(), bytes.NewBuffer(encodedPayload))
+
+	maxAttempts := client.Retry.attempts()
+	for attempt := 1; ; attempt++ {
+		err = client.attempt(ctx, bizOpsUrl.String(), encodedPayload, response)
+		if err == nil {
+			RequestAttempts.WithLabelValues("success").Inc()
+			return nil
+		}
+
+		retryErr, retryable := err.(*retryableError)
+		if !retryable {
+			RequestAttempts.WithLabelValues("error").Inc()
+			return err
+		}
+		RequestAttempts.WithLabelValues("retryable_error").Inc()
+
+		if attempt >= maxAttempts {
+			return retryErr.err
+		}
+		delay := client.Retry.delay(attempt, retryErr.retryAfter)
+		if client.Retry.MaxDelay > 0 && delay > client.Retry.MaxDelay {
+			// biz-ops has asked us to back off for longer than we are willing to wait
+			return retryErr.err
+		}
+
+		log.WithFields(log.Fields{
+			"event":   "BIZ_OPS_REQUEST_RETRY",
+			"attempt": attempt,
+			"delay":   delay.Seconds(),
+			"err":     retryErr.err,
+		}).Warn("Biz-Ops request failed, retrying.")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt makes a single request to biz-ops, wrapping transient failures in a *retryableError
+func (client *BizOpsClient) attempt(ctx context.Context, bizOpsUrl string, encodedPayload []byte, response interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, bizOpsUrl, bytes.NewBuffer(encodedPayload))
 	if err != nil {
 		return fmt.Errorf("biz-ops request creation failed (%v)", err)
 	}
+	req = req.WithContext(ctx)
 	req.Header.Add("X-Api-Key", client.APIKey)
 	req.Header.Add("User-Agent", "prometheus-biz-ops-service-discovery")
 	req.Header.Add("client-id", "prometheus-biz-ops-service-discovery")
 	req.Header.Add("Content-Type", "application/json")
 
+	start := time.Now()
+	status := "error"
+	defer func() {
+		RequestDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+	}()
+
 	resp, err := client.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("biz-ops request failed (%v)", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &retryableError{err: fmt.Errorf("biz-ops request failed (%v)", err)}
 	}
+	defer resp.Body.Close()
+	status = strconv.Itoa(resp.StatusCode)
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("biz-ops request failed to parse response body (%v)", err)
+		return &retryableError{err: fmt.Errorf("biz-ops request failed to parse response body (%v)", err)}
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -57,14 +190,31 @@ func (client *BizOpsClient) Query(query string, response interface{}) error {
 		gatewayError := new(APIGatewayResponse)
 		err = json.Unmarshal(body, &gatewayError)
 		if err != nil {
-			return fmt.Errorf("received %s from biz-ops: %s. (%v)", resp.Status, string(body), err)
+			err = fmt.Errorf("received %s from biz-ops: %s. (%v)", resp.Status, string(body), err)
+		} else {
+			err = fmt.Errorf("%v api gateway error: %s", resp.StatusCode, gatewayError.Message)
+		}
+		if isRetryableStatus(resp.StatusCode) {
+			return &retryableError{err: err, retryAfter: parseRetryAfter(resp)}
 		}
-		return fmt.Errorf("%v api gateway error: %s", resp.StatusCode, gatewayError.Message)
+		return err
+	}
+
+	var envelope graphQLEnvelope
+	err = json.Unmarshal(body, &envelope)
+	if err != nil {
+		return fmt.Errorf("biz-ops response unmarshalling failed: (%v)", err)
+	}
+	if len(envelope.Errors) > 0 && !envelope.hasData() {
+		return &GraphQLError{Errors: envelope.Errors}
 	}
 
 	err = json.Unmarshal(body, &response)
 	if err != nil {
 		return fmt.Errorf("biz-ops response unmarshalling failed: (%v)", err)
 	}
+	if len(envelope.Errors) > 0 {
+		return &GraphQLError{Errors: envelope.Errors, PartialData: true}
+	}
 	return nil
 }
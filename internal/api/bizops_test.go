@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -225,3 +228,168 @@ func TestBizOpsApiHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestBizOpsGraphQLErrors(t *testing.T) {
+	testCases := map[string]struct {
+		bizOpsResponse         string
+		expectedPartialData    bool
+		expectedErrors         []GraphQLErrorDetail
+		expectedParsedResponse HealthCheckGraphQLResponse
+	}{
+		"errors without data should return a total failure": {
+			bizOpsResponse: `{
+				"data": null,
+				"errors": [
+					{
+						"message": "Syntax Error: Expected Name, found <EOF>",
+						"locations": [{"line": 1, "column": 5}]
+					}
+				]
+			}`,
+			expectedPartialData: false,
+			expectedErrors: []GraphQLErrorDetail{
+				GraphQLErrorDetail{
+					Message:   "Syntax Error: Expected Name, found <EOF>",
+					Locations: []GraphQLErrorLocation{{Line: 1, Column: 5}},
+				},
+			},
+		},
+		"errors with data should return the partial data": {
+			bizOpsResponse: `{
+				"data": {
+					"Healthchecks": [
+						{
+							"code": "system-1",
+							"url": "http://system-1.in.ft.com/__health",
+							"isLive": true,
+							"monitors": null
+						}
+					]
+				},
+				"errors": [
+					{
+						"message": "Cannot return null for non-nullable field",
+						"path": ["Healthchecks", 0, "monitors"],
+						"extensions": {"code": "INTERNAL_SERVER_ERROR"}
+					}
+				]
+			}`,
+			expectedPartialData: true,
+			expectedErrors: []GraphQLErrorDetail{
+				GraphQLErrorDetail{
+					Message:    "Cannot return null for non-nullable field",
+					Path:       []interface{}{"Healthchecks", float64(0), "monitors"},
+					Extensions: map[string]interface{}{"code": "INTERNAL_SERVER_ERROR"},
+				},
+			},
+			expectedParsedResponse: HealthCheckGraphQLResponse{
+				HealthCheckData: HealthCheckData{
+					Healthchecks: []Healthcheck{
+						Healthcheck{
+							ID:     "system-1",
+							URL:    "http://system-1.in.ft.com/__health",
+							IsLive: true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			server := startTestServer(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(test.bizOpsResponse))
+			})
+			defer server.Close()
+
+			client := BizOpsClient{
+				Client:  http.Client{Timeout: 200 * time.Millisecond},
+				APIKey:  "dummy-key",
+				BaseUrl: server.URL,
+			}
+
+			var result HealthCheckGraphQLResponse
+			err := client.Query(`{ Healthchecks { code } }`, &result)
+
+			require.Error(t, err, "Expected error was not returned")
+			graphQLErr, ok := err.(*GraphQLError)
+			require.Truef(t, ok, "Expected a *GraphQLError but got %T", err)
+			assert.Equal(t, test.expectedPartialData, graphQLErr.PartialData, "PartialData was not as expected")
+			assert.Equal(t, test.expectedErrors, graphQLErr.Errors, "GraphQL errors were not decoded as expected")
+			assert.Equal(t, test.expectedParsedResponse, result, "Expected parsed biz-ops result to be equal to the expected result")
+		})
+	}
+}
+
+func TestQueryContextCancelled(t *testing.T) {
+	var attempts int32
+	server := startTestServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+
+	client := BizOpsClient{
+		Client:  http.Client{Timeout: 200 * time.Millisecond},
+		APIKey:  "dummy-key",
+		BaseUrl: server.URL,
+		Retry:   RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var result HealthCheckGraphQLResponse
+	err := client.QueryContext(ctx, `{ Healthchecks { code } }`, nil, &result)
+
+	assert.Equal(t, context.DeadlineExceeded, err, "Expected the context error to be returned")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "Expected no retries after the context was cancelled")
+}
+
+func TestExecuteSendsRequestEnvelope(t *testing.T) {
+	testCases := map[string]struct {
+		request         Request
+		expectedPayload string
+	}{
+		"query without variables should only send the query": {
+			request:         Request{Query: `{ Healthchecks { code } }`},
+			expectedPayload: `{"query": "{ Healthchecks { code } }"}`,
+		},
+		"variables and operation name should be sent in the envelope": {
+			request: Request{
+				Query:         `query Healthchecks($isLive: Boolean) { Healthchecks(filter: {isLive: $isLive}) { code } }`,
+				Variables:     Variables{"isLive": true},
+				OperationName: "Healthchecks",
+			},
+			expectedPayload: `{
+				"query": "query Healthchecks($isLive: Boolean) { Healthchecks(filter: {isLive: $isLive}) { code } }",
+				"variables": {"isLive": true},
+				"operationName": "Healthchecks"
+			}`,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			server := startTestServer(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err, "Error reading request body")
+				assert.JSONEq(t, test.expectedPayload, string(body), "Request payload was not as expected")
+				_, _ = w.Write([]byte(`{"data": {"Healthchecks": []}}`))
+			})
+			defer server.Close()
+
+			client := BizOpsClient{
+				Client:  http.Client{Timeout: 200 * time.Millisecond},
+				APIKey:  "dummy-key",
+				BaseUrl: server.URL,
+			}
+
+			var result HealthCheckGraphQLResponse
+			err := client.Execute(context.Background(), test.request, &result)
+			assert.NoError(t, err, "Error not expected")
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RequestDuration the time taken by each biz-ops request, labelled by HTTP status or error if no response was received
+var RequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "biz_ops_request_duration_seconds",
+		Help:    "Duration of biz-ops requests by HTTP status",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	},
+	[]string{"status"},
+)
+
+// RegisterMetrics registers the biz-ops client metrics with the given registerer
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(RequestAttempts)
+	registerer.MustRegister(RequestDuration)
+}
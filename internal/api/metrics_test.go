@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestCounts returns the number of observed requests for each status
+func requestCounts(t *testing.T) map[string]uint64 {
+	registry := prometheus.NewRegistry()
+	RegisterMetrics(registry)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	counts := map[string]uint64{}
+	for _, family := range families {
+		if family.GetName() != "biz_ops_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "status" {
+					counts[label.GetValue()] = metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestRequestDurationByStatus(t *testing.T) {
+	RequestDuration.Reset()
+
+	var attempts int32
+	server := startTestServer(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"Healthchecks": []}}`))
+	})
+	defer server.Close()
+
+	client := BizOpsClient{
+		Client:  http.Client{Timeout: 200 * time.Millisecond},
+		BaseUrl: server.URL,
+		Retry:   RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	}
+	var result HealthCheckGraphQLResponse
+	require.NoError(t, client.Query(`{ Healthchecks { code } }`, &result))
+
+	client.BaseUrl = "http://127.0.0.1:1"
+	client.Retry = RetryPolicy{}
+	assert.Error(t, client.Query(`{ Healthchecks { code } }`, &result))
+
+	assert.Equal(t, map[string]uint64{"502": 1, "200": 1, "error": 1}, requestCounts(t), "Requests were not observed by status")
+}
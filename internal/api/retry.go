@@ -0,0 +1,99 @@
+package api
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RequestAttempts counts every attempt made to query biz-ops, labelled by outcome
+var RequestAttempts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "biz_ops_request_attempts_total",
+		Help: "Number of attempted biz-ops requests by outcome",
+	},
+	[]string{"outcome"},
+)
+
+// RetryPolicy configures how failed biz-ops requests are retried.
+// The zero value makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the fraction (0-1) of each delay which is randomised
+	Jitter float64
+}
+
+func (policy RetryPolicy) attempts() int {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+// delay returns how long to wait before the attempt following the given one.
+// A Retry-After from the server is honoured if it is longer than the backoff.
+func (policy RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	backoff := float64(policy.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if policy.MaxDelay > 0 && backoff > float64(policy.MaxDelay) {
+		backoff = float64(policy.MaxDelay)
+	}
+	if policy.Jitter > 0 {
+		jitter := math.Min(policy.Jitter, 1)
+		backoff -= backoff * jitter * rand.Float64()
+	}
+	if delay := time.Duration(backoff); delay > retryAfter {
+		return delay
+	}
+	return retryAfter
+}
+
+// retryableError marks a failed attempt as safe to try again
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// isRetryableStatus whether a response status is likely to be transient.
+// Client errors such as a 403 from the API gateway are never retried.
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header given as either seconds or an HTTP date
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
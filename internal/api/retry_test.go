@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRetries(t *testing.T) {
+	testCases := map[string]struct {
+		responseCodes    []int
+		retryAfter       string
+		policy           RetryPolicy
+		expectedAttempts int32
+		expectErr        bool
+	}{
+		"transient gateway error should be retried until success": {
+			responseCodes:    []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			policy:           RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			expectedAttempts: 3,
+			expectErr:        false,
+		},
+		"forbidden from the api gateway should never be retried": {
+			responseCodes:    []int{http.StatusForbidden, http.StatusOK},
+			policy:           RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			expectedAttempts: 1,
+			expectErr:        true,
+		},
+		"should give up after the maximum number of attempts": {
+			responseCodes:    []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			policy:           RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			expectedAttempts: 3,
+			expectErr:        true,
+		},
+		"zero value policy should make a single attempt": {
+			responseCodes:    []int{http.StatusBadGateway, http.StatusOK},
+			policy:           RetryPolicy{},
+			expectedAttempts: 1,
+			expectErr:        true,
+		},
+		"retry-after longer than the maximum delay should not be waited for": {
+			responseCodes:    []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:       "120",
+			policy:           RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second},
+			expectedAttempts: 1,
+			expectErr:        true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			var attempts int32
+			server := startTestServer(func(w http.ResponseWriter, r *http.Request) {
+				attempt := atomic.AddInt32(&attempts, 1)
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.responseCodes[attempt-1])
+				_, _ = w.Write([]byte(`{"data": {"Healthchecks": []}}`))
+			})
+			defer server.Close()
+
+			client := BizOpsClient{
+				Client:  http.Client{Timeout: 200 * time.Millisecond},
+				APIKey:  "dummy-key",
+				BaseUrl: server.URL,
+				Retry:   test.policy,
+			}
+
+			var result HealthCheckGraphQLResponse
+			err := client.Query(`{ Healthchecks { code } }`, &result)
+
+			if test.expectErr {
+				assert.Error(t, err, "Expected error was not returned")
+			} else {
+				assert.NoError(t, err, "Error not expected")
+			}
+			assert.Equal(t, test.expectedAttempts, atomic.LoadInt32(&attempts), "Number of attempts was not as expected")
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, policy.delay(1, 0), "First retry should wait the base delay")
+	assert.Equal(t, 400*time.Millisecond, policy.delay(3, 0), "Delay should double with each attempt")
+	assert.Equal(t, time.Second, policy.delay(10, 0), "Delay should be capped at the max delay")
+	assert.Equal(t, 2*time.Second, policy.delay(1, 2*time.Second), "Retry-After should be honoured when longer than the backoff")
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.delay(2, 0)
+		assert.Truef(t, delay > 100*time.Millisecond && delay <= 200*time.Millisecond, "Jittered delay %v was out of range", delay)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Check a single health check in the FT health check standard
+type Check struct {
+	ID               string
+	Name             string
+	Severity         int
+	BusinessImpact   string
+	TechnicalSummary string
+	PanicGuide       string
+	// GTG whether the service is not good to go while the check fails. Checks of
+	// dependencies leave it unset so an outage elsewhere doesn't take the service out of service.
+	GTG bool
+	// Checker returns the output for the check, and an error if the check has failed
+	Checker func() (string, error)
+}
+
+// Health describes the system and the checks which make up its health
+type Health struct {
+	SystemCode  string
+	Name        string
+	Description string
+	Checks      []Check
+}
+
+// About the build information served at /__about
+type About struct {
+	SystemCode  string `json:"systemCode"`
+	Name        string `json:"name"`
+	Purpose     string `json:"purpose"`
+	ServiceTier string `json:"serviceTier"`
+	BuildInfo   struct {
+		Revision    string `json:"revision"`
+		BuildNumber string `json:"buildNumber"`
+		BuildDate   string `json:"buildDate"`
+	} `json:"buildInfo"`
+}
+
+type checkResult struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	OK               bool   `json:"ok"`
+	Severity         int    `json:"severity"`
+	BusinessImpact   string `json:"businessImpact"`
+	TechnicalSummary string `json:"technicalSummary"`
+	PanicGuide       string `json:"panicGuide"`
+	CheckOutput      string `json:"checkOutput"`
+	LastUpdated      string `json:"lastUpdated"`
+}
+
+type healthResult struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	SystemCode    string        `json:"systemCode"`
+	Name          string        `json:"name"`
+	Description   string        `json:"description"`
+	Checks        []checkResult `json:"checks"`
+	OK            bool          `json:"ok"`
+}
+
+func (health Health) run() healthResult {
+	result := healthResult{
+		SchemaVersion: 1,
+		SystemCode:    health.SystemCode,
+		Name:          health.Name,
+		Description:   health.Description,
+		Checks:        make([]checkResult, 0, len(health.Checks)),
+		OK:            true,
+	}
+	for _, check := range health.Checks {
+		output, err := check.Checker()
+		if err != nil {
+			output = err.Error()
+			result.OK = false
+		}
+		result.Checks = append(result.Checks, checkResult{
+			ID:               check.ID,
+			Name:             check.Name,
+			OK:               err == nil,
+			Severity:         check.Severity,
+			BusinessImpact:   check.BusinessImpact,
+			TechnicalSummary: check.TechnicalSummary,
+			PanicGuide:       check.PanicGuide,
+			CheckOutput:      output,
+			LastUpdated:      time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	return result
+}
+
+// HealthHandler serves the result of every check in the FT health check format
+func HealthHandler(health Health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(health.run())
+	})
+}
+
+// GTGHandler responds 200 when every GTG check passes and 503 otherwise
+func GTGHandler(health Health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=US-ASCII")
+		w.Header().Set("Cache-Control", "no-store")
+		for _, check := range health.Checks {
+			if !check.GTG {
+				continue
+			}
+			if _, err := check.Checker(); err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		_, _ = w.Write([]byte("OK"))
+	})
+}
+
+// AboutHandler serves the build information
+func AboutHandler(about About) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(about)
+	})
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHealth(checkErrs ...error) Health {
+	health := Health{SystemCode: "system-code", Name: "System", Description: "A system"}
+	for i, err := range checkErrs {
+		err := err
+		health.Checks = append(health.Checks, Check{
+			ID:       fmt.Sprintf("check-%d", i),
+			Name:     fmt.Sprintf("Check %d", i),
+			Severity: 1,
+			GTG:      true,
+			Checker: func() (string, error) {
+				return "all good", err
+			},
+		})
+	}
+	return health
+}
+
+func TestHealthHandler(t *testing.T) {
+	testCases := map[string]struct {
+		health         Health
+		expectedOK     bool
+		expectedChecks []bool
+	}{
+		"passing checks should be ok": {
+			health:         newHealth(nil, nil),
+			expectedOK:     true,
+			expectedChecks: []bool{true, true},
+		},
+		"a failing check should not be ok": {
+			health:         newHealth(nil, errors.New("broken")),
+			expectedOK:     false,
+			expectedChecks: []bool{true, false},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			HealthHandler(test.health).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/__health", nil))
+
+			assert.Equal(t, http.StatusOK, recorder.Code, "Status code was not as expected")
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "Content type was not as expected")
+
+			var result healthResult
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result), "Error not expected parsing the health")
+			assert.Equal(t, 1, result.SchemaVersion, "Schema version was not as expected")
+			assert.Equal(t, "system-code", result.SystemCode, "System code was not as expected")
+			assert.Equal(t, test.expectedOK, result.OK, "Overall health was not as expected")
+			require.Len(t, result.Checks, len(test.expectedChecks), "Number of checks was not as expected")
+			for i, expected := range test.expectedChecks {
+				assert.Equalf(t, expected, result.Checks[i].OK, "Check %d was not as expected", i)
+				if !expected {
+					assert.Equal(t, "broken", result.Checks[i].CheckOutput, "Failing check output should be the error")
+				}
+			}
+		})
+	}
+}
+
+func TestGTGHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	GTGHandler(newHealth(nil, nil)).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/__gtg", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected good to go when every check passes")
+
+	recorder = httptest.NewRecorder()
+	GTGHandler(newHealth(nil, errors.New("broken"))).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/__gtg", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code, "Expected not good to go when a check fails")
+
+	health := newHealth(nil, errors.New("dependency down"))
+	health.Checks[1].GTG = false
+	recorder = httptest.NewRecorder()
+	GTGHandler(health).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/__gtg", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected a failing dependency check not to affect good to go")
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+	log "github.com/sirupsen/logrus"
+)
+
+// RefreshFunc runs service discovery, or waits for the run in progress, and returns its outcome
+type RefreshFunc func(context.Context) (servicediscovery.Result, error)
+
+type refreshError struct {
+	Error string `json:"error"`
+}
+
+// RefreshHandler triggers an immediate service discovery run on an authenticated POST and responds with the resulting diff.
+// The API key is looked up on every request so it can be changed while running, the endpoint is disabled while it is empty.
+func RefreshHandler(refresh RefreshFunc, apiKey func() string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKey()
+		if key == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Api-Key")), []byte(key)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"event": "REFRESH_REQUESTED",
+		}).Info("A service discovery refresh was requested.")
+
+		result, err := refresh(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(refreshError{Error: err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
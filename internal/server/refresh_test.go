@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshHandler(t *testing.T) {
+	testCases := map[string]struct {
+		method       string
+		apiKey       string
+		refreshErr   error
+		expectedCode int
+		expectedBody string
+		expectRun    bool
+		disabled     bool
+	}{
+		"authenticated post should run discovery and return the diff": {
+			method:       http.MethodPost,
+			apiKey:       "refresh-key",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"changed": true, "diff": {"added": [{"target": "https://url.com"}], "removed": [], "relabelled": []}}`,
+			expectRun:    true,
+		},
+		"failed run should return the error": {
+			method:       http.MethodPost,
+			apiKey:       "refresh-key",
+			refreshErr:   errors.New("biz-ops API call failed"),
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: `{"error": "biz-ops API call failed"}`,
+			expectRun:    true,
+		},
+		"wrong api key should be unauthorized": {
+			method:       http.MethodPost,
+			apiKey:       "wrong-key",
+			expectedCode: http.StatusUnauthorized,
+		},
+		"missing api key should be unauthorized": {
+			method:       http.MethodPost,
+			expectedCode: http.StatusUnauthorized,
+		},
+		"unset api key should disable the endpoint": {
+			method:       http.MethodPost,
+			disabled:     true,
+			expectedCode: http.StatusNotFound,
+		},
+		"get should not be allowed": {
+			method:       http.MethodGet,
+			apiKey:       "refresh-key",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			ran := false
+			refresh := func(ctx context.Context) (servicediscovery.Result, error) {
+				ran = true
+				if test.refreshErr != nil {
+					return servicediscovery.Result{}, test.refreshErr
+				}
+				return servicediscovery.Result{
+					Changed: true,
+					Diff: servicediscovery.TargetDiff{
+						Added:      []servicediscovery.TargetChange{{Target: "https://url.com"}},
+						Removed:    []servicediscovery.TargetChange{},
+						Relabelled: []servicediscovery.TargetChange{},
+					},
+				}, nil
+			}
+
+			req := httptest.NewRequest(test.method, "/refresh", nil)
+			if test.apiKey != "" {
+				req.Header.Set("X-Api-Key", test.apiKey)
+			}
+			recorder := httptest.NewRecorder()
+
+			apiKey := func() string {
+				if test.disabled {
+					return ""
+				}
+				return "refresh-key"
+			}
+
+			RefreshHandler(refresh, apiKey).ServeHTTP(recorder, req)
+
+			assert.Equal(t, test.expectedCode, recorder.Code, "Status code was not as expected")
+			assert.Equal(t, test.expectRun, ran, "Whether discovery ran was not as expected")
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, recorder.Body.String(), "Body was not as expected")
+			}
+		})
+	}
+}
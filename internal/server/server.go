@@ -3,15 +3,46 @@ package server
 import (
 	"log"
 	"net/http"
+	"strings"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
-func Server(listenAddress string) *http.Server {
+// TargetProvider supplies the current target groups in the Prometheus file_sd/http_sd JSON format
+type TargetProvider interface {
+	Targets() (content []byte, etag string, ok bool)
+}
+
+// Options the data and handlers the server exposes
+type Options struct {
+	// Metrics the registry served at /metrics, the default registry if unset
+	Metrics *prometheus.Registry
+	Targets TargetProvider
+	Health  Health
+	About   About
+	// Refresh triggers a service discovery run, the /refresh endpoint is disabled if it or the RefreshAPIKey is unset
+	Refresh RefreshFunc
+	// RefreshAPIKey returns the key required to call /refresh, which is disabled while it returns an empty key
+	RefreshAPIKey func() string
+}
+
+func Server(listenAddress string, options Options) *http.Server {
 	router := http.NewServeMux()
 
-	router.Handle("/metrics", promhttp.Handler())
+	if options.Metrics != nil {
+		router.Handle("/metrics", promhttp.InstrumentMetricHandler(options.Metrics, promhttp.HandlerFor(options.Metrics, promhttp.HandlerOpts{})))
+	} else {
+		router.Handle("/metrics", promhttp.Handler())
+	}
+	router.Handle("/targets", TargetsHandler(options.Targets))
+	router.Handle("/__health", HealthHandler(options.Health))
+	router.Handle("/__gtg", GTGHandler(options.Health))
+	router.Handle("/__about", AboutHandler(options.About))
+	if options.Refresh != nil && options.RefreshAPIKey != nil {
+		router.Handle("/refresh", RefreshHandler(options.Refresh, options.RefreshAPIKey))
+	}
 
 	logger := logrus.New()
 	w := logger.Writer()
@@ -25,3 +56,44 @@ func Server(listenAddress string) *http.Server {
 
 	return server
 }
+
+// TargetsHandler serves the target groups for use by a Prometheus http_sd_config
+func TargetsHandler(targets TargetProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		content, etag, ok := targets.Targets()
+		if !ok {
+			// Prometheus keeps the targets it already has on a non-200 response
+			http.Error(w, "service discovery has not yet completed successfully", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	})
+}
+
+// etagMatches whether an If-None-Match header matches the current ETag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticTargets struct {
+	content []byte
+	etag    string
+}
+
+func (targets staticTargets) Targets() ([]byte, string, bool) {
+	return targets.content, targets.etag, targets.content != nil
+}
+
+func TestTargetsHandler(t *testing.T) {
+	content := []byte(`[{"targets": ["https://url.com"], "labels": {"observe": "yes"}}]`)
+	testCases := map[string]struct {
+		targets      staticTargets
+		method       string
+		ifNoneMatch  string
+		expectedCode int
+		expectedBody string
+	}{
+		"should serve the targets": {
+			targets:      staticTargets{content: content, etag: `"abc"`},
+			method:       http.MethodGet,
+			expectedCode: http.StatusOK,
+			expectedBody: string(content),
+		},
+		"should return not modified when the etag matches": {
+			targets:      staticTargets{content: content, etag: `"abc"`},
+			method:       http.MethodGet,
+			ifNoneMatch:  `"xyz", "abc"`,
+			expectedCode: http.StatusNotModified,
+		},
+		"should serve the targets when the etag has changed": {
+			targets:      staticTargets{content: content, etag: `"abc"`},
+			method:       http.MethodGet,
+			ifNoneMatch:  `"xyz"`,
+			expectedCode: http.StatusOK,
+			expectedBody: string(content),
+		},
+		"should be unavailable before discovery has succeeded": {
+			targets:      staticTargets{},
+			method:       http.MethodGet,
+			expectedCode: http.StatusServiceUnavailable,
+		},
+		"should not allow other methods": {
+			targets:      staticTargets{content: content, etag: `"abc"`},
+			method:       http.MethodPost,
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/targets", nil)
+			if test.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			recorder := httptest.NewRecorder()
+
+			TargetsHandler(test.targets).ServeHTTP(recorder, req)
+
+			assert.Equal(t, test.expectedCode, recorder.Code, "Status code was not as expected")
+			if test.expectedBody != "" {
+				assert.Equal(t, test.expectedBody, recorder.Body.String(), "Body was not as expected")
+				assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "Content type was not as expected")
+				assert.Equal(t, test.targets.etag, recorder.Header().Get("ETag"), "ETag was not as expected")
+			}
+		})
+	}
+}
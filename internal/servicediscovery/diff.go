@@ -0,0 +1,149 @@
+package servicediscovery
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// TargetsAdded the number of targets added to the configuration
+var TargetsAdded = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_targets_added_total",
+		Help: "Number of targets added to the service discovery configuration",
+	},
+)
+
+// TargetsRemoved the number of targets removed from the configuration
+var TargetsRemoved = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_targets_removed_total",
+		Help: "Number of targets removed from the service discovery configuration",
+	},
+)
+
+// TargetsRelabelled the number of targets whose labels changed in the configuration
+var TargetsRelabelled = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_targets_relabelled_total",
+		Help: "Number of targets whose labels changed in the service discovery configuration",
+	},
+)
+
+// TargetChange a target which was added, removed or relabelled, with every label set it is written with
+type TargetChange struct {
+	Target         string              `json:"target"`
+	Labels         []map[string]string `json:"labels,omitempty"`
+	PreviousLabels []map[string]string `json:"previousLabels,omitempty"`
+}
+
+// TargetDiff the changes between two configurations
+type TargetDiff struct {
+	Added      []TargetChange `json:"added"`
+	Removed    []TargetChange `json:"removed"`
+	Relabelled []TargetChange `json:"relabelled"`
+}
+
+// Empty whether there were no changes
+func (diff TargetDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Relabelled) == 0
+}
+
+// targetLabels maps each target to every label set it is written with, in a stable order
+func targetLabels(configuration []prometheusConfiguration) map[string][]labels {
+	byTarget := map[string][]labels{}
+	for _, group := range configuration {
+		for _, target := range group.Targets {
+			byTarget[target] = append(byTarget[target], group.Labels)
+		}
+	}
+	for _, sets := range byTarget {
+		sort.Slice(sets, func(i, j int) bool { return sets[i].key() < sets[j].key() })
+	}
+	return byTarget
+}
+
+func sameLabelSets(a []labels, b []labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].key() != b[i].key() {
+			return false
+		}
+	}
+	return true
+}
+
+func toMaps(sets []labels) []map[string]string {
+	maps := make([]map[string]string, len(sets))
+	for i, set := range sets {
+		maps[i] = map[string]string(set)
+	}
+	return maps
+}
+
+// diffConfigurations compares the targets, and their labels, in two configurations
+func diffConfigurations(previous []prometheusConfiguration, next []prometheusConfiguration) TargetDiff {
+	previousTargets := targetLabels(previous)
+	nextTargets := targetLabels(next)
+	diff := TargetDiff{
+		Added:      make([]TargetChange, 0),
+		Removed:    make([]TargetChange, 0),
+		Relabelled: make([]TargetChange, 0),
+	}
+
+	for target, nextLabels := range nextTargets {
+		previousLabels, existed := previousTargets[target]
+		if !existed {
+			diff.Added = append(diff.Added, TargetChange{Target: target, Labels: toMaps(nextLabels)})
+		} else if !sameLabelSets(previousLabels, nextLabels) {
+			diff.Relabelled = append(diff.Relabelled, TargetChange{
+				Target:         target,
+				Labels:         toMaps(nextLabels),
+				PreviousLabels: toMaps(previousLabels),
+			})
+		}
+	}
+	for target, previousLabels := range previousTargets {
+		if _, exists := nextTargets[target]; !exists {
+			diff.Removed = append(diff.Removed, TargetChange{Target: target, PreviousLabels: toMaps(previousLabels)})
+		}
+	}
+
+	for _, changes := range [][]TargetChange{diff.Added, diff.Removed, diff.Relabelled} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Target < changes[j].Target })
+	}
+	return diff
+}
+
+// record logs an event for every change and counts them
+func (diff TargetDiff) record() {
+	for _, change := range diff.Added {
+		log.WithFields(log.Fields{
+			"event":  "TARGET_ADDED",
+			"target": change.Target,
+			"labels": change.Labels,
+		}).Info("A health check target was added.")
+	}
+	for _, change := range diff.Removed {
+		log.WithFields(log.Fields{
+			"event":          "TARGET_REMOVED",
+			"target":         change.Target,
+			"previousLabels": change.PreviousLabels,
+		}).Info("A health check target was removed.")
+	}
+	for _, change := range diff.Relabelled {
+		log.WithFields(log.Fields{
+			"event":          "TARGET_RELABELLED",
+			"target":         change.Target,
+			"labels":         change.Labels,
+			"previousLabels": change.PreviousLabels,
+		}).Info("The labels of a health check target changed.")
+	}
+
+	TargetsAdded.Add(float64(len(diff.Added)))
+	TargetsRemoved.Add(float64(len(diff.Removed)))
+	TargetsRelabelled.Add(float64(len(diff.Relabelled)))
+}
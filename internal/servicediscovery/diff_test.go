@@ -0,0 +1,42 @@
+package servicediscovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfigurations(t *testing.T) {
+	previous := []prometheusConfiguration{
+		{Targets: []string{"https://kept.com", "https://removed.com"}, Labels: labels{"observe": "yes", "system": "a"}},
+		{Targets: []string{"https://relabelled.com"}, Labels: labels{"observe": "no", "system": "b"}},
+		{Targets: []string{"https://shared.com"}, Labels: labels{"observe": "yes", "system": "c"}},
+		{Targets: []string{"https://shared.com"}, Labels: labels{"observe": "yes", "system": "d"}},
+	}
+	next := []prometheusConfiguration{
+		{Targets: []string{"https://shared.com"}, Labels: labels{"observe": "yes", "system": "d"}},
+		{Targets: []string{"https://added.com", "https://kept.com"}, Labels: labels{"observe": "yes", "system": "a"}},
+		{Targets: []string{"https://relabelled.com"}, Labels: labels{"observe": "yes", "system": "b"}},
+		{Targets: []string{"https://shared.com"}, Labels: labels{"observe": "yes", "system": "c"}},
+	}
+
+	diff := diffConfigurations(previous, next)
+
+	assert.Equal(t, TargetDiff{
+		Added: []TargetChange{
+			{Target: "https://added.com", Labels: []map[string]string{{"observe": "yes", "system": "a"}}},
+		},
+		Removed: []TargetChange{
+			{Target: "https://removed.com", PreviousLabels: []map[string]string{{"observe": "yes", "system": "a"}}},
+		},
+		Relabelled: []TargetChange{
+			{
+				Target:         "https://relabelled.com",
+				Labels:         []map[string]string{{"observe": "yes", "system": "b"}},
+				PreviousLabels: []map[string]string{{"observe": "no", "system": "b"}},
+			},
+		},
+	}, diff, "Diff was not as expected")
+	assert.False(t, diff.Empty(), "Expected the diff to have changes")
+	assert.True(t, diffConfigurations(previous, previous).Empty(), "Expected no changes between identical configurations")
+}
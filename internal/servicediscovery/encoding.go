@@ -0,0 +1,87 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Encoder serialises the configuration in a format Prometheus file_sd reads
+type Encoder interface {
+	// Extension the filename extension of the format, including the dot
+	Extension() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(content []byte, v interface{}) error
+}
+
+// JSONEncoder writes the configuration as indented JSON
+type JSONEncoder struct{}
+
+// Extension is .json
+func (JSONEncoder) Extension() string {
+	return ".json"
+}
+
+// Encode marshals v as JSON indented by two spaces
+func (JSONEncoder) Encode(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// Decode unmarshals the JSON into v
+func (JSONEncoder) Decode(content []byte, v interface{}) error {
+	return json.Unmarshal(content, v)
+}
+
+// YAMLEncoder writes the configuration as YAML
+type YAMLEncoder struct{}
+
+// Extension is .yaml
+func (YAMLEncoder) Extension() string {
+	return ".yaml"
+}
+
+// Encode marshals v as YAML
+func (YAMLEncoder) Encode(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+// Decode unmarshals the YAML into v, failing on unknown fields
+func (YAMLEncoder) Decode(content []byte, v interface{}) error {
+	return yaml.UnmarshalStrict(content, v)
+}
+
+// EncoderFor returns the encoder for the named format, json or yaml
+func EncoderFor(format string) (Encoder, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return JSONEncoder{}, nil
+	case "yaml", "yml":
+		return YAMLEncoder{}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, must be json or yaml", format)
+}
+
+// EncoderForFile returns the encoder Prometheus uses for the file, by its extension
+func EncoderForFile(path string) Encoder {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAMLEncoder{}
+	}
+	return JSONEncoder{}
+}
+
+// withExtension replaces the extension of the filename with the encoder's
+func withExtension(filename string, encoder Encoder) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + encoder.Extension()
+}
+
+// encoder returns the configured encoder, JSON by default, rewriting the targets for the exporter if one is set
+func (bizOps *BizOps) encoder() Encoder {
+	if bizOps.Encoder == nil {
+		return bizOps.withExporter(JSONEncoder{})
+	}
+	return bizOps.withExporter(bizOps.Encoder)
+}
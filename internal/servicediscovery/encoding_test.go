@@ -0,0 +1,61 @@
+package servicediscovery
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunWritesYAML(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	targets := NewTargetStore()
+	serviceDiscovery := BizOps{
+		Writer: NewFileWriter("/etc/prometheus", memoryFS),
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				systemHealthcheck("https://a.ft.com", "system-a"),
+			}),
+		},
+		Encoder: YAMLEncoder{},
+		Targets: targets,
+	}
+
+	_, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+
+	content, err := afero.ReadFile(memoryFS, filepath.Join("/etc/prometheus", "health-check-service-discovery.yaml"))
+	require.NoError(t, err, "Expected the configuration to be written with the yaml extension")
+	expected := `- targets:
+  - https://a.ft.com
+  labels:
+    observe: "yes"
+    system: system-a
+`
+	assert.Equal(t, expected, string(content), "Expected the configuration as YAML")
+	assert.Empty(t, ValidateEncodedConfiguration(content, YAMLEncoder{}), "Expected a valid file_sd file")
+
+	served, _, _ := targets.Targets()
+	assert.Empty(t, ValidateConfiguration(served), "Expected the served targets to stay JSON")
+
+	unchanged := testutil.ToFloat64(UnchangedRuns)
+	_, err = serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+	assert.Equal(t, unchanged+1, testutil.ToFloat64(UnchangedRuns), "Expected the unchanged YAML not to be rewritten")
+}
+
+func TestEncoderFor(t *testing.T) {
+	encoder, err := EncoderFor("YAML")
+	require.NoError(t, err)
+	assert.Equal(t, YAMLEncoder{}, encoder)
+
+	_, err = EncoderFor("toml")
+	assert.Error(t, err, "Expected an unknown format to fail")
+
+	assert.Equal(t, YAMLEncoder{}, EncoderForFile("/etc/prometheus/targets.yml"))
+	assert.Equal(t, JSONEncoder{}, EncoderForFile("/etc/prometheus/targets.json"))
+}
@@ -0,0 +1,123 @@
+package servicediscovery
+
+import "fmt"
+
+// labels the exporter needs, which are set on every rewritten target
+const (
+	endpointParamLabel = "__param_endpoint"
+	moduleParamLabel   = "__param_module"
+	metricsPathLabel   = "__metrics_path__"
+	schemeLabel        = "__scheme__"
+	instanceLabel      = "instance"
+)
+
+// ProbeExporter an exporter, such as the health check exporter or blackbox exporter, which
+// scrapes each healthcheck on Prometheus' behalf. The targets are rewritten to be scraped
+// through it so Prometheus doesn't need relabel_configs to do so.
+type ProbeExporter struct {
+	// Address the host and port of the exporter, which becomes every target
+	Address string
+	// Scheme the exporter is scraped with, Prometheus' default if empty
+	Scheme string
+	// MetricsPath the exporter is scraped at, Prometheus' default if empty
+	MetricsPath string
+	// Module passed to the exporter, not passed if empty
+	Module string
+}
+
+// Validate checks the exporter can be scraped
+func (exporter ProbeExporter) Validate() error {
+	if exporter.Address == "" {
+		return fmt.Errorf("the exporter address must be set")
+	}
+	if exporter.Scheme != "" && exporter.Scheme != "http" && exporter.Scheme != "https" {
+		return fmt.Errorf("the exporter scheme %q must be http or https", exporter.Scheme)
+	}
+	return nil
+}
+
+// rewrite points every target at the exporter, with the healthcheck url as its endpoint and instance
+func (exporter ProbeExporter) rewrite(configuration []prometheusConfiguration) []prometheusConfiguration {
+	rewritten := make([]prometheusConfiguration, 0)
+	for _, group := range configuration {
+		for _, target := range group.Targets {
+			targetLabels := labels{}
+			for name, value := range group.Labels {
+				targetLabels[name] = value
+			}
+			targetLabels[endpointParamLabel] = target
+			targetLabels[instanceLabel] = target
+			if exporter.Module != "" {
+				targetLabels[moduleParamLabel] = exporter.Module
+			}
+			if exporter.MetricsPath != "" {
+				targetLabels[metricsPathLabel] = exporter.MetricsPath
+			}
+			if exporter.Scheme != "" {
+				targetLabels[schemeLabel] = exporter.Scheme
+			}
+			rewritten = append(rewritten, prometheusConfiguration{Targets: []string{exporter.Address}, Labels: targetLabels})
+		}
+	}
+	sortConfiguration(rewritten)
+	return rewritten
+}
+
+// restore reverses rewrite, so configuration written through the exporter can be compared with the targets
+func (exporter ProbeExporter) restore(configuration []prometheusConfiguration) []prometheusConfiguration {
+	targets := make([]Target, 0)
+	for _, group := range configuration {
+		endpoint, ok := group.Labels[endpointParamLabel]
+		if !ok {
+			for _, target := range group.Targets {
+				targets = append(targets, Target{URL: target, Labels: group.Labels})
+			}
+			continue
+		}
+		targetLabels := map[string]string{}
+		for name, value := range group.Labels {
+			switch name {
+			case endpointParamLabel, instanceLabel, moduleParamLabel, metricsPathLabel, schemeLabel:
+			default:
+				targetLabels[name] = value
+			}
+		}
+		targets = append(targets, Target{URL: endpoint, Labels: targetLabels})
+	}
+	restored := groupTargets(targets)
+	sortConfiguration(restored)
+	return restored
+}
+
+// exporterEncoder writes the configuration rewritten for the exporter in the wrapped encoder's format
+type exporterEncoder struct {
+	Encoder
+	exporter ProbeExporter
+}
+
+// Encode rewrites the configuration for the exporter before encoding it
+func (encoder exporterEncoder) Encode(v interface{}) ([]byte, error) {
+	if configuration, ok := v.([]prometheusConfiguration); ok {
+		v = encoder.exporter.rewrite(configuration)
+	}
+	return encoder.Encoder.Encode(v)
+}
+
+// Decode restores the targets the configuration was rewritten from
+func (encoder exporterEncoder) Decode(content []byte, v interface{}) error {
+	if err := encoder.Encoder.Decode(content, v); err != nil {
+		return err
+	}
+	if configuration, ok := v.(*[]prometheusConfiguration); ok {
+		*configuration = encoder.exporter.restore(*configuration)
+	}
+	return nil
+}
+
+// withExporter wraps the encoder to rewrite the targets for the exporter, if one is set
+func (bizOps *BizOps) withExporter(encoder Encoder) Encoder {
+	if bizOps.Exporter == nil {
+		return encoder
+	}
+	return exporterEncoder{Encoder: encoder, exporter: *bizOps.Exporter}
+}
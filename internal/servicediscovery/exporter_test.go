@@ -0,0 +1,71 @@
+package servicediscovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRewritesTargetsForTheExporter(t *testing.T) {
+	writer := RecordingWriter{}
+	targets := NewTargetStore()
+	serviceDiscovery := BizOps{
+		Writer: &writer,
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				systemHealthcheck("https://a.ft.com/__health", "system-a"),
+			}),
+		},
+		Targets:  targets,
+		Exporter: &ProbeExporter{Address: "prometheus-health-check-exporter.in.ft.com", Scheme: "https", MetricsPath: "/scrape"},
+	}
+
+	_, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+
+	expected := `[
+  {
+    "targets": [
+      "prometheus-health-check-exporter.in.ft.com"
+    ],
+    "labels": {
+      "__metrics_path__": "/scrape",
+      "__param_endpoint": "https://a.ft.com/__health",
+      "__scheme__": "https",
+      "instance": "https://a.ft.com/__health",
+      "observe": "yes",
+      "system": "system-a"
+    }
+  }
+]`
+	assert.Equal(t, expected, string(writer.content), "Expected the target to be the exporter")
+	served, _, _ := targets.Targets()
+	assert.Equal(t, expected, string(served), "Expected the served targets to be rewritten too")
+
+	result, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+	assert.False(t, result.Changed, "Expected the rewritten configuration to be unchanged")
+	assert.Equal(t, 1, writer.writes, "Expected the unchanged configuration not to be rewritten")
+}
+
+func TestProbeExporterRestore(t *testing.T) {
+	exporter := ProbeExporter{Address: "blackbox:9115", Module: "http_2xx"}
+	configuration := []prometheusConfiguration{
+		{Targets: []string{"https://a.ft.com", "https://b.ft.com"}, Labels: labels{"observe": "yes"}},
+		{Targets: []string{"https://c.ft.com"}, Labels: labels{"observe": "no"}},
+	}
+	sortConfiguration(configuration)
+
+	rewritten := exporter.rewrite(configuration)
+	require.Len(t, rewritten, 3, "Expected a group for each target")
+	for _, group := range rewritten {
+		assert.Equal(t, []string{"blackbox:9115"}, group.Targets)
+		assert.Equal(t, "http_2xx", group.Labels[moduleParamLabel])
+		assert.Equal(t, group.Labels[endpointParamLabel], group.Labels[instanceLabel])
+	}
+
+	assert.Equal(t, configuration, exporter.restore(rewritten), "Expected the targets to be restored")
+	assert.Equal(t, 3, countTargets(exporter.restore(rewritten)), "Expected the blast radius to count the healthchecks, not the exporter")
+}
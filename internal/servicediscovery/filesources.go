@@ -0,0 +1,103 @@
+package servicediscovery
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+)
+
+// StaticFileSource reads manually maintained targets from a YAML (or JSON) file
+// listing each target's url and optional labels
+type StaticFileSource struct {
+	Path string
+	fs   afero.Fs
+}
+
+type staticTarget struct {
+	URL    string            `yaml:"url"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// NewStaticFileSource returns a source for the targets in the file, using the OS filesystem if fs is nil
+func NewStaticFileSource(path string, fs afero.Fs) *StaticFileSource {
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+	return &StaticFileSource{Path: path, fs: fs}
+}
+
+// Name identifies the file as a Source
+func (source *StaticFileSource) Name() string {
+	return "static:" + source.Path
+}
+
+// Discover reads the targets from the file, failing if any of them are not valid
+func (source *StaticFileSource) Discover(ctx context.Context) ([]Target, error) {
+	content, err := afero.ReadFile(source.fs, source.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s (%v)", source.Path, err)
+	}
+
+	var entries []staticTarget
+	if err := yaml.UnmarshalStrict(content, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s (%v)", source.Path, err)
+	}
+
+	targets := make([]Target, 0, len(entries))
+	for index, entry := range entries {
+		if _, err := url.ParseRequestURI(entry.URL); err != nil {
+			return nil, fmt.Errorf("target %d in %s has an invalid url %q", index, source.Path, entry.URL)
+		}
+		for name := range entry.Labels {
+			if !labelNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("target %d in %s has an invalid label name %q", index, source.Path, name)
+			}
+		}
+		targets = append(targets, Target{URL: entry.URL, Labels: entry.Labels})
+	}
+	return targets, nil
+}
+
+// FileSDSource reads the targets from another Prometheus file_sd file, YAML if it has a .yaml or .yml extension and JSON otherwise
+type FileSDSource struct {
+	Path string
+	fs   afero.Fs
+}
+
+// NewFileSDSource returns a source for the targets in the file_sd file, using the OS filesystem if fs is nil
+func NewFileSDSource(path string, fs afero.Fs) *FileSDSource {
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+	return &FileSDSource{Path: path, fs: fs}
+}
+
+// Name identifies the file as a Source
+func (source *FileSDSource) Name() string {
+	return "file_sd:" + source.Path
+}
+
+// Discover reads the targets from the file, failing only if it can't be parsed. Anything
+// else Prometheus accepts, such as an empty list, is read as it is; the validate command lints it.
+func (source *FileSDSource) Discover(ctx context.Context) ([]Target, error) {
+	content, err := afero.ReadFile(source.fs, source.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s (%v)", source.Path, err)
+	}
+
+	var configuration []prometheusConfiguration
+	if err := EncoderForFile(source.Path).Decode(content, &configuration); err != nil {
+		return nil, fmt.Errorf("failed to parse %s (%v)", source.Path, err)
+	}
+
+	targets := make([]Target, 0)
+	for _, group := range configuration {
+		for _, target := range group.Targets {
+			targets = append(targets, Target{URL: target, Labels: group.Labels})
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,95 @@
+package servicediscovery
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticFileSource(t *testing.T) {
+	testCases := map[string]struct {
+		content         string
+		expectedTargets []Target
+		expectedErr     string
+	}{
+		"targets should be read with their labels": {
+			content: `
+- url: https://third-party.example.com/__health
+  labels:
+    system: third-party
+    observe: "yes"
+- url: https://other.example.com/__health
+`,
+			expectedTargets: []Target{
+				{URL: "https://third-party.example.com/__health", Labels: map[string]string{"system": "third-party", "observe": "yes"}},
+				{URL: "https://other.example.com/__health"},
+			},
+		},
+		"invalid url should fail": {
+			content:     "- url: not a url\n",
+			expectedErr: `target 0 in /static.yaml has an invalid url "not a url"`,
+		},
+		"invalid label name should fail": {
+			content:     "- url: https://other.example.com/__health\n  labels:\n    1bad: x\n",
+			expectedErr: `target 0 in /static.yaml has an invalid label name "1bad"`,
+		},
+		"unknown keys should fail": {
+			content:     "- address: https://other.example.com/__health\n",
+			expectedErr: "failed to parse /static.yaml",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/static.yaml", []byte(test.content), 0644))
+
+			targets, err := NewStaticFileSource("/static.yaml", fs).Discover(context.Background())
+
+			if test.expectedErr != "" {
+				require.Error(t, err, "Expected error was not returned")
+				assert.Contains(t, err.Error(), test.expectedErr, "Error was not as expected")
+				return
+			}
+			require.NoError(t, err, "Error not expected")
+			assert.Equal(t, test.expectedTargets, targets, "Targets were not as expected")
+		})
+	}
+}
+
+func TestFileSDSource(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/other.json", []byte(`[
+		{"targets": ["https://one.example.com/__health", "https://two.example.com/__health"], "labels": {"system": "other"}}
+	]`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/empty.json", []byte(`[]`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/repeated.json", []byte(`[
+		{"targets": ["https://one.example.com/__health", "https://one.example.com/__health"]}
+	]`), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/invalid.json", []byte(`{"targets": []}`), 0644))
+
+	targets, err := NewFileSDSource("/other.json", fs).Discover(context.Background())
+	require.NoError(t, err, "Error not expected")
+	assert.Equal(t, []Target{
+		{URL: "https://one.example.com/__health", Labels: map[string]string{"system": "other"}},
+		{URL: "https://two.example.com/__health", Labels: map[string]string{"system": "other"}},
+	}, targets, "Targets were not as expected")
+
+	targets, err = NewFileSDSource("/empty.json", fs).Discover(context.Background())
+	require.NoError(t, err, "Expected an empty file to be read as Prometheus reads it")
+	assert.Empty(t, targets, "Expected no targets from an empty file")
+
+	targets, err = NewFileSDSource("/repeated.json", fs).Discover(context.Background())
+	require.NoError(t, err, "Expected a repeated target to be read as Prometheus reads it")
+	assert.Len(t, targets, 2, "Expected the targets as they are listed")
+
+	_, err = NewFileSDSource("/invalid.json", fs).Discover(context.Background())
+	assert.Error(t, err, "Expected a file which isn't a list of groups to fail")
+
+	_, err = NewFileSDSource("/missing.json", fs).Discover(context.Background())
+	assert.Error(t, err, "Expected a missing file to fail")
+}
@@ -1,17 +1,31 @@
 package servicediscovery
 
 import (
+	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 )
 
 // Filename the filename of the service discovery config
 const Filename = "health-check-service-discovery.json"
 
+// tempFilePrefixFor the prefix of the temp files used when writing the named file,
+// hidden and without the .json extension so a temp file is never matched by a file_sd glob
+func tempFilePrefixFor(filename string) string {
+	return "." + filename + ".tmp-"
+}
+
+// staleTempFileAge how old a temp file must be before it is assumed to have been abandoned by a failed write
+const staleTempFileAge = 10 * time.Minute
+
 type fileWriter struct {
 	Directory string
+	Filename  string
 	fs        afero.Fs
 }
 
@@ -20,12 +34,91 @@ func NewFileWriter(directory string, fs afero.Fs) io.Writer {
 	if fs == nil {
 		fs = afero.NewOsFs()
 	}
-	return fileWriter{Directory: directory, fs: fs}
+	return fileWriter{Directory: directory, Filename: Filename, fs: fs}
+}
+
+// WithFilename returns a writer for another file in the same directory
+func (fileWriter fileWriter) WithFilename(filename string) io.Writer {
+	fileWriter.Filename = filename
+	return fileWriter
+}
+
+// Remove deletes the named file from the directory
+func (fileWriter fileWriter) Remove(filename string) error {
+	return fileWriter.fs.Remove(filepath.Join(fileWriter.Directory, filename))
 }
 
+// Write atomically replaces the config file by writing to a temp file in the same
+// directory and renaming it over the config, so a reader never sees it half-written.
 func (fileWriter fileWriter) Write(p []byte) (n int, err error) {
-	if err := afero.WriteFile(fileWriter.fs, filepath.Join(fileWriter.Directory, Filename), p, 0644); err != nil {
+	fileWriter.removeStaleTempFiles()
+
+	if err := writeFileAtomically(fileWriter.fs, filepath.Join(fileWriter.Directory, fileWriter.Filename), p); err != nil {
 		return 0, err
 	}
 	return len(p), nil
 }
+
+// writeFileAtomically replaces the file with the content via a hidden temp file in the same directory
+func writeFileAtomically(fs afero.Fs, path string, content []byte) (err error) {
+	tempFile, err := afero.TempFile(fs, filepath.Dir(path), tempFilePrefixFor(filepath.Base(path)))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file (%v)", err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if err != nil {
+			_ = fs.Remove(tempPath)
+		}
+	}()
+
+	if _, err = tempFile.Write(content); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write temp file (%v)", err)
+	}
+	if err = tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to sync temp file (%v)", err)
+	}
+	if err = tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file (%v)", err)
+	}
+	if err = fs.Chmod(tempPath, 0644); err != nil {
+		return fmt.Errorf("failed to set temp file permissions (%v)", err)
+	}
+	if err = fs.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s (%v)", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// removeStaleTempFiles removes temp files left behind by writes which did not complete.
+// Recent temp files are left alone as they may belong to a write in progress.
+func (fileWriter fileWriter) removeStaleTempFiles() {
+	tempFiles, err := afero.Glob(fileWriter.fs, filepath.Join(fileWriter.Directory, tempFilePrefixFor(fileWriter.Filename)+"*"))
+	if err != nil {
+		return
+	}
+	for _, tempFile := range tempFiles {
+		info, err := fileWriter.fs.Stat(tempFile)
+		if err != nil || time.Since(info.ModTime()) < staleTempFileAge {
+			continue
+		}
+		if err := fileWriter.fs.Remove(tempFile); err != nil {
+			log.WithFields(log.Fields{
+				"event": "ERROR_REMOVING_TEMP_FILE",
+				"file":  tempFile,
+				"err":   err,
+			}).Warn("Failed to remove a stale temp file.")
+		}
+	}
+}
+
+// LastWritten returns the contents of the existing file, or nil if there isn't one
+func (fileWriter fileWriter) LastWritten() ([]byte, error) {
+	content, err := afero.ReadFile(fileWriter.fs, filepath.Join(fileWriter.Directory, fileWriter.Filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
@@ -3,6 +3,7 @@ package servicediscovery
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -71,3 +72,73 @@ func TestWritesTheCorrectContent(t *testing.T) {
 	assert.Exactly(t, content, written, "Expected correct content to be written to file but it did not match")
 	assert.Exactly(t, bytesWritten, bytesRead, "Expected bytes written to match bytes read from file")
 }
+
+func TestLastWrittenReturnsTheExistingContent(t *testing.T) {
+	content := []byte("{\"some\": \"json\"}")
+	memoryFS := afero.NewMemMapFs()
+
+	fileWriter := NewFileWriter("/test-dir/path", memoryFS).(fileWriter)
+	lastWritten, err := fileWriter.LastWritten()
+	assert.NoError(t, err, "Expected no error when there is no existing file")
+	assert.Nil(t, lastWritten, "Expected no content when there is no existing file")
+
+	_, err = fileWriter.Write(content)
+	if err != nil {
+		t.Errorf("error running write: \"%s\"", err)
+	}
+	lastWritten, err = fileWriter.LastWritten()
+	assert.NoError(t, err, "Expected no error reading the existing file")
+	assert.Exactly(t, content, lastWritten, "Expected the written content to be returned")
+}
+
+func TestWriteLeavesNoTempFiles(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+
+	fileWriter := NewFileWriter("/test-dir/path", memoryFS)
+	for _, content := range []string{"first", "second"} {
+		_, err := fileWriter.Write([]byte(content))
+		if err != nil {
+			t.Errorf("error running write: \"%s\"", err)
+		}
+	}
+
+	files, err := afero.ReadDir(memoryFS, "/test-dir/path")
+	if err != nil {
+		t.Errorf("error reading directory: \"%s\"", err)
+	}
+	assert.Len(t, files, 1, "Expected only the config file to be in the directory")
+	assert.Exactly(t, Filename, files[0].Name(), "Expected only the config file to be in the directory")
+	assert.Exactly(t, os.FileMode(0644), files[0].Mode().Perm(), "Expected the config file to be readable by everyone")
+
+	written, err := afero.ReadFile(memoryFS, filepath.Join("/test-dir/path", Filename))
+	if err != nil {
+		t.Errorf("error reading file: \"%s\"", err)
+	}
+	assert.Exactly(t, []byte("second"), written, "Expected the config file to be replaced")
+}
+
+func TestWriteRemovesStaleTempFiles(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	stalePath := filepath.Join("/test-dir/path", tempFilePrefixFor(Filename)+"stale")
+	recentPath := filepath.Join("/test-dir/path", tempFilePrefixFor(Filename)+"recent")
+	for _, path := range []string{stalePath, recentPath} {
+		if err := afero.WriteFile(memoryFS, path, []byte("partial"), 0600); err != nil {
+			t.Errorf("error creating temp file: \"%s\"", err)
+		}
+	}
+	staleTime := time.Now().Add(-2 * staleTempFileAge)
+	if err := memoryFS.Chtimes(stalePath, staleTime, staleTime); err != nil {
+		t.Errorf("error ageing temp file: \"%s\"", err)
+	}
+
+	fileWriter := NewFileWriter("/test-dir/path", memoryFS)
+	_, err := fileWriter.Write([]byte("file"))
+	if err != nil {
+		t.Errorf("error running write: \"%s\"", err)
+	}
+
+	_, err = memoryFS.Stat(stalePath)
+	assert.True(t, os.IsNotExist(err), "Expected the stale temp file to be removed")
+	_, err = memoryFS.Stat(recentPath)
+	assert.NoError(t, err, "Expected the recent temp file to be left for the write in progress")
+}
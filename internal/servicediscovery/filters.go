@@ -0,0 +1,180 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FilteredTargets the number of targets each filter rule has dropped
+var FilteredTargets = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "service_discovery_filtered_targets_total",
+		Help: "Number of targets dropped by each filter rule",
+	},
+	[]string{"rule"},
+)
+
+// FilterRule includes or excludes healthchecks by one of their attributes.
+// Rules are applied in order, each include rule dropping the targets it does not
+// match and each exclude rule dropping the targets it does.
+type FilterRule struct {
+	Exclude bool
+	// Field one of system, host, live or label:<name>
+	Field   string
+	Pattern string
+	match   func(string) bool
+}
+
+// ParseFilterRule parses a rule given in the form include|exclude:field=pattern, e.g.
+//
+//	exclude:system=legacy-*         the system label (or every system code if it isn't mapped) is matched by glob, alternatives separated by commas
+//	include:host=\.in\.ft\.com$     URL hosts are matched by regular expression
+//	exclude:live=false              whether the healthcheck is live
+//	include:label:team=team-a,team-b  any mapped label is matched by glob
+func ParseFilterRule(value string) (FilterRule, error) {
+	actionAndRule := strings.SplitN(value, ":", 2)
+	if len(actionAndRule) != 2 {
+		return FilterRule{}, fmt.Errorf("filter rule %q is not in the form include|exclude:field=pattern", value)
+	}
+	fieldAndPattern := strings.SplitN(actionAndRule[1], "=", 2)
+	if len(fieldAndPattern) != 2 {
+		return FilterRule{}, fmt.Errorf("filter rule %q is not in the form include|exclude:field=pattern", value)
+	}
+
+	rule := FilterRule{
+		Field:   strings.TrimSpace(fieldAndPattern[0]),
+		Pattern: strings.TrimSpace(fieldAndPattern[1]),
+	}
+	switch strings.TrimSpace(actionAndRule[0]) {
+	case "include":
+	case "exclude":
+		rule.Exclude = true
+	default:
+		return FilterRule{}, fmt.Errorf("filter rule %q must start with include or exclude", value)
+	}
+
+	if err := rule.compile(); err != nil {
+		return FilterRule{}, err
+	}
+	return rule, nil
+}
+
+func (rule *FilterRule) compile() error {
+	switch {
+	case rule.Field == "host":
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid host pattern %q (%v)", rule.Pattern, err)
+		}
+		rule.match = pattern.MatchString
+	case rule.Field == "live":
+		live, err := strconv.ParseBool(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid live value %q, expected true or false", rule.Pattern)
+		}
+		expected := strconv.FormatBool(live)
+		rule.match = func(value string) bool { return value == expected }
+	case rule.Field == "system" || strings.HasPrefix(rule.Field, "label:"):
+		if rule.Field == "label:" {
+			return fmt.Errorf("filter rule %s is missing a label name", rule)
+		}
+		globs := strings.Split(rule.Pattern, ",")
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("invalid glob %q (%v)", glob, err)
+			}
+		}
+		rule.match = func(value string) bool {
+			for _, glob := range globs {
+				if matched, _ := path.Match(glob, value); matched {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		return fmt.Errorf("unknown filter field %q, expected system, host, live or label:<name>", rule.Field)
+	}
+	return nil
+}
+
+func (rule FilterRule) String() string {
+	action := "include"
+	if rule.Exclude {
+		action = "exclude"
+	}
+	return fmt.Sprintf("%s:%s=%s", action, rule.Field, rule.Pattern)
+}
+
+// fieldPath the healthcheck field the rule needs from biz-ops, if any
+func (rule FilterRule) fieldPath() []string {
+	switch rule.Field {
+	case "system":
+		return []string{"monitors", "code"}
+	case "live":
+		return []string{"isLive"}
+	}
+	return nil
+}
+
+func (rule FilterRule) values(healthcheck Healthcheck, fields map[string]interface{}, targetLabels labels) []string {
+	switch rule.Field {
+	case "host":
+		parsed, err := url.Parse(healthcheck.URL)
+		if err != nil {
+			return nil
+		}
+		return []string{parsed.Hostname()}
+	case "live":
+		values := resolveFieldPath(fields, rule.fieldPath())
+		if len(values) == 0 {
+			return []string{"false"}
+		}
+		return values
+	case "system":
+		// the label set's own system, so a check monitoring several systems is only dropped from the matching groups
+		if value, ok := targetLabels["system"]; ok {
+			return []string{value}
+		}
+		return resolveFieldPath(fields, rule.fieldPath())
+	}
+	if value, ok := targetLabels[strings.TrimPrefix(rule.Field, "label:")]; ok {
+		return []string{value}
+	}
+	return nil
+}
+
+// keeps whether the target survives the rule, which must have been compiled
+func (rule FilterRule) keeps(healthcheck Healthcheck, fields map[string]interface{}, targetLabels labels) bool {
+	matched := false
+	for _, value := range rule.values(healthcheck, fields, targetLabels) {
+		if rule.match(value) {
+			matched = true
+			break
+		}
+	}
+	return matched != rule.Exclude
+}
+
+func (rule FilterRule) reason() string {
+	if rule.Exclude {
+		return "matched exclude rule"
+	}
+	return "did not match include rule"
+}
+
+// droppedBy returns the index of the first rule which drops the target, or -1 if it is kept
+func droppedBy(rules []FilterRule, healthcheck Healthcheck, fields map[string]interface{}, targetLabels labels) int {
+	for index, rule := range rules {
+		if !rule.keeps(healthcheck, fields, targetLabels) {
+			return index
+		}
+	}
+	return -1
+}
@@ -0,0 +1,107 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilterRule(t *testing.T) {
+	testCases := map[string]struct {
+		value       string
+		expectedErr bool
+	}{
+		"system glob should be valid":       {value: "exclude:system=legacy-*"},
+		"host regular expression is valid":  {value: `include:host=\.in\.ft\.com$`},
+		"live flag should be valid":         {value: "exclude:live=false"},
+		"label glob should be valid":        {value: "include:label:team=team-a,team-b"},
+		"missing action should error":       {value: "system=legacy-*", expectedErr: true},
+		"unknown action should error":       {value: "drop:system=legacy-*", expectedErr: true},
+		"unknown field should error":        {value: "exclude:team=team-a", expectedErr: true},
+		"missing label name should error":   {value: "exclude:label:=team-a", expectedErr: true},
+		"invalid regular expression errors": {value: "include:host=(", expectedErr: true},
+		"invalid live value should error":   {value: "include:live=maybe", expectedErr: true},
+		"invalid glob should error":         {value: "include:system=[", expectedErr: true},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			rule, err := ParseFilterRule(test.value)
+			if test.expectedErr {
+				assert.Error(t, err, "Expected error was not returned")
+				return
+			}
+			require.NoError(t, err, "Error not expected")
+			assert.Equal(t, test.value, rule.String(), "Rule should format as it was given")
+		})
+	}
+}
+
+func TestWriteAppliesFilters(t *testing.T) {
+	healthchecks := []Healthcheck{
+		{URL: "https://live.in.ft.com/__health", IsLive: true, Systems: []System{{SystemCode: "live-system"}}},
+		{URL: "https://legacy.in.ft.com/__health", IsLive: true, Systems: []System{{SystemCode: "legacy-system"}}},
+		{URL: "https://not-live.in.ft.com/__health", IsLive: false, Systems: []System{{SystemCode: "other-system"}}},
+		{URL: "https://external.com/__health", IsLive: true, Systems: []System{{SystemCode: "external-system"}}},
+		{URL: "https://shared.in.ft.com/__health", IsLive: true, Systems: []System{{SystemCode: "shared"}, {SystemCode: "ignored"}}},
+		{URL: "https://mixed.in.ft.com/__health", IsLive: true, Systems: []System{{SystemCode: "mixed"}, {SystemCode: "legacy-mixed"}}},
+	}
+
+	var filters []FilterRule
+	for _, value := range []string{
+		`include:host=\.in\.ft\.com$`,
+		"exclude:system=legacy-*",
+		"exclude:live=false",
+		"exclude:label:system=ignored",
+	} {
+		rule, err := ParseFilterRule(value)
+		require.NoError(t, err, "Error not expected parsing filter rule")
+		filters = append(filters, rule)
+	}
+
+	writer := MockWriter{}
+	writer.On("Write", mock.Anything).Return(1, nil)
+	serviceDiscovery := BizOps{
+		Writer:    &writer,
+		ApiClient: &MockAPIClient{response: newGraphQLResponse(healthchecks)},
+		Filters:   filters,
+	}
+
+	rules := map[string]float64{
+		`include:host=\.in\.ft\.com$`:  1,
+		"exclude:system=legacy-*":      2,
+		"exclude:live=false":           1,
+		"exclude:label:system=ignored": 1,
+	}
+	before := map[string]float64{}
+	for rule := range rules {
+		before[rule] = testutil.ToFloat64(FilteredTargets.WithLabelValues(rule))
+	}
+
+	err := serviceDiscovery.Write()
+	require.NoError(t, err, "Error not expected")
+
+	writtenString := string(writer.Calls[0].Arguments.Get(0).([]byte))
+	assert.JSONEq(t, `[
+		{
+			"targets": ["https://live.in.ft.com/__health"],
+			"labels": {"observe": "yes", "system": "live-system"}
+		},
+		{
+			"targets": ["https://mixed.in.ft.com/__health"],
+			"labels": {"observe": "yes", "system": "mixed"}
+		},
+		{
+			"targets": ["https://shared.in.ft.com/__health"],
+			"labels": {"observe": "yes", "system": "shared"}
+		}
+	]`, writtenString, "JSON created was not as expected")
+
+	for rule, expected := range rules {
+		assert.Equalf(t, before[rule]+expected, testutil.ToFloat64(FilteredTargets.WithLabelValues(rule)), "Dropped targets for rule %s were not as expected", rule)
+	}
+}
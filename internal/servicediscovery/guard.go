@@ -0,0 +1,131 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// WriteBlocked is 1 while the blast radius guard is refusing to write the configuration
+var WriteBlocked = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_write_blocked",
+		Help: "Whether the service discovery configuration write is being blocked because too many targets would be removed",
+	},
+)
+
+// BlastRadiusGuard refuses to replace the existing configuration with one with drastically fewer targets.
+// A zero limit disables that check.
+type BlastRadiusGuard struct {
+	// MaxDropPercent the largest percentage of the existing targets which may be removed
+	MaxDropPercent float64
+	// MaxDropCount the largest number of the existing targets which may be removed
+	MaxDropCount int
+	// Force writes the configuration regardless of how many targets are removed
+	Force bool
+}
+
+// check returns an error if replacing the previous configuration with the next would remove too many targets
+func (guard BlastRadiusGuard) check(previous []prometheusConfiguration, next []prometheusConfiguration) error {
+	previousCount := countTargets(previous)
+	nextCount := countTargets(next)
+	dropped := previousCount - nextCount
+	if previousCount == 0 || dropped <= 0 {
+		return nil
+	}
+
+	droppedPercent := float64(dropped) / float64(previousCount) * 100
+	exceeded := (guard.MaxDropPercent > 0 && droppedPercent > guard.MaxDropPercent) ||
+		(guard.MaxDropCount > 0 && dropped > guard.MaxDropCount)
+	if !exceeded {
+		return nil
+	}
+
+	fields := log.Fields{
+		"event":          "CONFIGURATION_WRITE_BLOCKED",
+		"previousCount":  previousCount,
+		"nextCount":      nextCount,
+		"droppedPercent": droppedPercent,
+	}
+	if guard.Force {
+		fields["event"] = "CONFIGURATION_WRITE_FORCED"
+		log.WithFields(fields).Warn("Writing a configuration which removes more targets than allowed as the write is forced.")
+		return nil
+	}
+
+	err := fmt.Errorf("refusing to remove %d of %d targets (%.1f%%)", dropped, previousCount, droppedPercent)
+	fields["err"] = err
+	log.WithFields(fields).Error("Health check targets were not updated as too many targets would be removed.")
+	return err
+}
+
+// checkBlastRadius compares the configuration with the one last written, if there is one
+func (bizOps *BizOps) checkBlastRadius(previous []prometheusConfiguration, configuration []prometheusConfiguration) error {
+	if previous == nil {
+		WriteBlocked.Set(0)
+		return nil
+	}
+
+	if err := bizOps.Guard.check(previous, configuration); err != nil {
+		WriteBlocked.Set(1)
+		return err
+	}
+	WriteBlocked.Set(0)
+	return nil
+}
+
+// checkPartitions checks how many targets left the partition they were last written to, counting the
+// partitions which vanished, so an emptied label can't move every target into the unmatched partition
+func (bizOps *BizOps) checkPartitions(outputs []output, stale []output) error {
+	previous := make([]prometheusConfiguration, 0)
+	written := map[string]bool{}
+	for _, list := range [][]output{outputs, stale} {
+		for _, out := range list {
+			for _, group := range out.previous {
+				targets := make([]string, 0, len(group.Targets))
+				for _, target := range group.Targets {
+					targets = append(targets, partitionTarget(out, target))
+					written[partitionTarget(out, target)] = true
+				}
+				previous = append(previous, prometheusConfiguration{Targets: targets, Labels: group.Labels})
+			}
+		}
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+
+	// only the targets still in the same partition are kept
+	kept := make([]prometheusConfiguration, 0)
+	for _, out := range outputs {
+		for _, group := range out.configuration {
+			targets := make([]string, 0, len(group.Targets))
+			for _, target := range group.Targets {
+				if written[partitionTarget(out, target)] {
+					targets = append(targets, partitionTarget(out, target))
+				}
+			}
+			kept = append(kept, prometheusConfiguration{Targets: targets, Labels: group.Labels})
+		}
+	}
+	return bizOps.checkBlastRadius(previous, kept)
+}
+
+// partitionTarget qualifies the target with its file, ignoring the extension so changing the format doesn't move it
+func partitionTarget(out output, target string) string {
+	return strings.TrimSuffix(out.filename, filepath.Ext(out.filename)) + "\x00" + target
+}
+
+// countTargets the number of distinct targets in the configuration
+func countTargets(configuration []prometheusConfiguration) int {
+	targets := map[string]bool{}
+	for _, group := range configuration {
+		for _, target := range group.Targets {
+			targets[target] = true
+		}
+	}
+	return len(targets)
+}
@@ -0,0 +1,108 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConfiguration(targetCount int) []prometheusConfiguration {
+	targets := make([]string, targetCount)
+	for i := range targets {
+		targets[i] = fmt.Sprintf("https://system%d.com/__health", i)
+	}
+	return []prometheusConfiguration{{Targets: targets, Labels: labels{"observe": "yes"}}}
+}
+
+func TestBlastRadiusGuardCheck(t *testing.T) {
+	testCases := map[string]struct {
+		guard         BlastRadiusGuard
+		previousCount int
+		nextCount     int
+		expectedErr   bool
+	}{
+		"drop within the percentage should be allowed": {
+			guard:         BlastRadiusGuard{MaxDropPercent: 50},
+			previousCount: 10,
+			nextCount:     5,
+		},
+		"drop over the percentage should be refused": {
+			guard:         BlastRadiusGuard{MaxDropPercent: 50},
+			previousCount: 10,
+			nextCount:     4,
+			expectedErr:   true,
+		},
+		"drop over the count should be refused": {
+			guard:         BlastRadiusGuard{MaxDropCount: 2},
+			previousCount: 10,
+			nextCount:     7,
+			expectedErr:   true,
+		},
+		"growth should always be allowed": {
+			guard:         BlastRadiusGuard{MaxDropPercent: 1, MaxDropCount: 1},
+			previousCount: 10,
+			nextCount:     20,
+		},
+		"no previous targets should always be allowed": {
+			guard:         BlastRadiusGuard{MaxDropPercent: 1, MaxDropCount: 1},
+			previousCount: 0,
+			nextCount:     20,
+		},
+		"zero limits should allow any drop": {
+			guard:         BlastRadiusGuard{},
+			previousCount: 10,
+			nextCount:     1,
+		},
+		"forced write should be allowed": {
+			guard:         BlastRadiusGuard{MaxDropPercent: 10, Force: true},
+			previousCount: 10,
+			nextCount:     1,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			err := test.guard.check(newConfiguration(test.previousCount), newConfiguration(test.nextCount))
+			if test.expectedErr {
+				assert.Error(t, err, "Expected error was not returned")
+			} else {
+				assert.NoError(t, err, "Error not expected")
+			}
+		})
+	}
+}
+
+func TestWriteRefusesToRemoveTooManyTargets(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	previous, err := json.Marshal(newConfiguration(10))
+	require.NoError(t, err, "Error not expected marshalling the previous configuration")
+	path := filepath.Join("/test-dir", Filename)
+	require.NoError(t, afero.WriteFile(memoryFS, path, previous, 0644), "Error not expected writing the previous configuration")
+
+	serviceDiscovery := BizOps{
+		Writer: NewFileWriter("/test-dir", memoryFS),
+		ApiClient: &MockAPIClient{response: newGraphQLResponse([]Healthcheck{
+			{URL: "https://system0.com/__health", IsLive: true},
+		})},
+		Guard: BlastRadiusGuard{MaxDropPercent: 50},
+	}
+
+	err = serviceDiscovery.Write()
+	assert.Error(t, err, "Expected the write to be refused")
+	assert.Equal(t, float64(1), testutil.ToFloat64(WriteBlocked), "Expected the write to be reported as blocked")
+	written, _ := afero.ReadFile(memoryFS, path)
+	assert.Equal(t, previous, written, "Expected the previous configuration to be left in place")
+
+	serviceDiscovery.Guard.Force = true
+	err = serviceDiscovery.Write()
+	assert.NoError(t, err, "Expected the forced write to succeed")
+	assert.Equal(t, float64(0), testutil.ToFloat64(WriteBlocked), "Expected the write to no longer be reported as blocked")
+	written, _ = afero.ReadFile(memoryFS, path)
+	assert.NotEqual(t, previous, written, "Expected the configuration to be replaced")
+}
@@ -0,0 +1,248 @@
+package servicediscovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// healthchecksQuery builds the query for every healthcheck, or a page of them, selecting the given fields.
+// Pages are ordered by code so concurrent pages neither overlap nor skip healthchecks.
+func healthchecksQuery(fieldPaths [][]string, paged bool) string {
+	if paged {
+		return fmt.Sprintf("query Healthchecks($first: Int, $offset: Int) {\n  Healthchecks(first: $first, offset: $offset, orderBy: code_asc) {\n%s\n  }\n}", selectionSet(fieldPaths))
+	}
+	return fmt.Sprintf("query Healthchecks {\n  Healthchecks {\n%s\n  }\n}", selectionSet(fieldPaths))
+}
+
+// queryFieldPaths the healthcheck fields needed to build the targets, their labels and apply the filters
+func (bizOps *BizOps) queryFieldPaths() [][]string {
+	fieldPaths := [][]string{{"code"}, {"url"}}
+	for _, mapping := range bizOps.labelMappings() {
+		fieldPaths = append(fieldPaths, mapping.fieldPath())
+	}
+	for _, rule := range bizOps.Filters {
+		if fieldPath := rule.fieldPath(); fieldPath != nil {
+			fieldPaths = append(fieldPaths, fieldPath)
+		}
+	}
+	return fieldPaths
+}
+
+// selectionSet builds the GraphQL selection set for the given healthcheck field paths
+func selectionSet(fieldPaths [][]string) string {
+	type node struct {
+		names    []string
+		children map[string]*node
+	}
+	newNode := func() *node { return &node{children: map[string]*node{}} }
+
+	root := newNode()
+	add := func(path []string) {
+		current := root
+		for _, name := range path {
+			child, ok := current.children[name]
+			if !ok {
+				child = newNode()
+				current.children[name] = child
+				current.names = append(current.names, name)
+			}
+			current = child
+		}
+	}
+
+	for _, path := range fieldPaths {
+		add(path)
+	}
+
+	var render func(n *node, indent string) string
+	render = func(n *node, indent string) string {
+		lines := make([]string, 0, len(n.names))
+		for _, name := range n.names {
+			child := n.children[name]
+			if len(child.names) == 0 {
+				lines = append(lines, indent+name)
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s%s {\n%s\n%s}", indent, name, render(child, indent+"  "), indent))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return render(root, "    ")
+}
+
+// FetchError is returned when the healthchecks could not be fetched from biz-ops.
+// The error from the client, such as an *api.GraphQLError, is available from Cause.
+type FetchError struct {
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the error from the biz-ops client, also when it was returned for a page
+func (e *FetchError) Cause() error {
+	if pageErr, ok := e.Err.(*PageError); ok {
+		return pageErr.Cause()
+	}
+	return e.Err
+}
+
+// Unwrap returns the error from the biz-ops client
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// PageError is returned when a page of healthchecks could not be fetched.
+// The error from the client, such as an *api.GraphQLError, is available from Cause.
+type PageError struct {
+	Index int
+	Err   error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("fetching page %d of healthchecks failed: %v", e.Index, e.Err)
+}
+
+// Cause returns the error from the biz-ops client
+func (e *PageError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the error from the biz-ops client
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+type pageResult struct {
+	index        int
+	healthchecks []Healthcheck
+	// partial the error returned with partial data, nil if the page was complete
+	partial *api.GraphQLError
+	err     error
+}
+
+// fetchHealthchecks returns every healthcheck in biz-ops, paging through them if a PageSize is set.
+// Any failed page fails the whole fetch so a truncated set of healthchecks is never returned.
+func (bizOps *BizOps) fetchHealthchecks(ctx context.Context) ([]Healthcheck, error) {
+	if bizOps.PageSize <= 0 {
+		healthchecks, _, err := bizOps.fetchPage(ctx, api.Request{
+			Query:         healthchecksQuery(bizOps.queryFieldPaths(), false),
+			OperationName: "Healthchecks",
+		})
+		return healthchecks, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := bizOps.PageConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	query := healthchecksQuery(bizOps.queryFieldPaths(), true)
+	results := make(chan pageResult)
+	pages := map[int][]Healthcheck{}
+	next, inFlight := 0, 0
+	// the index of the first page with fewer healthchecks than the page size, -1 until it is found
+	lastPage := -1
+	var fetchErr error
+
+	fetchNext := func() {
+		index := next
+		next++
+		inFlight++
+		go func() {
+			healthchecks, partial, err := bizOps.fetchPage(ctx, api.Request{
+				Query:         query,
+				OperationName: "Healthchecks",
+				Variables: api.Variables{
+					"first":  bizOps.PageSize,
+					"offset": index * bizOps.PageSize,
+				},
+			})
+			results <- pageResult{index: index, healthchecks: healthchecks, partial: partial, err: err}
+		}()
+	}
+
+	for i := 0; i < workers; i++ {
+		fetchNext()
+	}
+
+	for inFlight > 0 {
+		result := <-results
+		inFlight--
+
+		if result.err == nil && len(result.healthchecks) > bizOps.PageSize {
+			result.err = fmt.Errorf("biz-ops returned %d healthchecks for a page size of %d", len(result.healthchecks), bizOps.PageSize)
+		}
+		// a short page ends the fetch, which partial data can't be trusted to do as its list may have been nulled
+		if result.err == nil && result.partial != nil && len(result.healthchecks) < bizOps.PageSize {
+			log.WithFields(log.Fields{
+				"event":        "BIZ_OPS_PARTIAL_PAGE_SHORT",
+				"page":         result.index,
+				"healthchecks": len(result.healthchecks),
+				"err":          result.partial,
+			}).Error("Biz-Ops returned partial data for a page with fewer healthchecks than the page size, it may be truncated.")
+			result.err = result.partial
+		}
+		if result.err != nil {
+			if fetchErr == nil {
+				fetchErr = &PageError{Index: result.index, Err: result.err}
+				cancel()
+			}
+			continue
+		}
+
+		pages[result.index] = result.healthchecks
+		if len(result.healthchecks) < bizOps.PageSize && (lastPage == -1 || result.index < lastPage) {
+			lastPage = result.index
+		}
+		if fetchErr == nil && lastPage == -1 {
+			fetchNext()
+		}
+	}
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	healthchecks := make([]Healthcheck, 0)
+	for index := 0; index <= lastPage; index++ {
+		healthchecks = append(healthchecks, pages[index]...)
+	}
+
+	log.WithFields(log.Fields{
+		"event":        "HEALTHCHECKS_FETCHED",
+		"pages":        lastPage + 1,
+		"healthchecks": len(healthchecks),
+	}).Debug("Fetched all pages of healthchecks from Biz-Ops.")
+
+	return healthchecks, nil
+}
+
+// fetchPage makes a single healthchecks request to biz-ops, also returning the error
+// which came with the healthchecks if they are partial data
+func (bizOps *BizOps) fetchPage(ctx context.Context, request api.Request) ([]Healthcheck, *api.GraphQLError, error) {
+	var responsePayload GraphQLResponse
+	err := bizOps.ApiClient.Execute(ctx, request, &responsePayload)
+
+	if err != nil {
+		graphQLErr, ok := err.(*api.GraphQLError)
+		if !ok || !graphQLErr.PartialData || !bizOps.AllowPartialData {
+			return nil, nil, err
+		}
+		log.WithFields(log.Fields{
+			"event": "BIZ_OPS_PARTIAL_DATA",
+			"err":   err,
+		}).Warn("Biz-Ops returned partial data, continuing with the healthchecks received.")
+		return responsePayload.Data.Healthchecks, graphQLErr, nil
+	}
+
+	return responsePayload.Data.Healthchecks, nil, nil
+}
@@ -0,0 +1,154 @@
+package servicediscovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type PagingAPIClient struct {
+	healthchecks []Healthcheck
+	failOffset   int
+	failErr      error
+	mutex        sync.Mutex
+	offsets      []int
+	queries      []string
+}
+
+func (c *PagingAPIClient) Execute(ctx context.Context, request api.Request, response interface{}) error {
+	first := request.Variables["first"].(int)
+	offset := request.Variables["offset"].(int)
+
+	c.mutex.Lock()
+	c.offsets = append(c.offsets, offset)
+	c.queries = append(c.queries, request.Query)
+	c.mutex.Unlock()
+
+	if c.failOffset > 0 && offset == c.failOffset {
+		// partial data comes back with the page's list nulled
+		if graphQLErr, ok := c.failErr.(*api.GraphQLError); ok && graphQLErr.PartialData {
+			*response.(*GraphQLResponse) = newGraphQLResponse(nil)
+		}
+		return c.failErr
+	}
+
+	page := make([]Healthcheck, 0)
+	for i := offset; i < offset+first && i < len(c.healthchecks); i++ {
+		page = append(page, c.healthchecks[i])
+	}
+	*response.(*GraphQLResponse) = newGraphQLResponse(page)
+	return nil
+}
+
+func newHealthchecks(count int) []Healthcheck {
+	healthchecks := make([]Healthcheck, count)
+	for i := range healthchecks {
+		healthchecks[i] = Healthcheck{
+			ID:  fmt.Sprintf("system%d.check", i),
+			URL: fmt.Sprintf("https://system%d.com/__health", i),
+		}
+	}
+	return healthchecks
+}
+
+func TestFetchHealthchecksPaged(t *testing.T) {
+	partialDataError := &api.GraphQLError{
+		Errors:      []api.GraphQLErrorDetail{{Message: "Cannot return null for non-nullable field"}},
+		PartialData: true,
+	}
+	totalFailureError := &api.GraphQLError{
+		Errors: []api.GraphQLErrorDetail{{Message: "Internal server error"}},
+	}
+	testCases := map[string]struct {
+		total            int
+		pageSize         int
+		concurrency      int
+		failOffset       int
+		failErr          error
+		allowPartialData bool
+		expectedErr      error
+	}{
+		"should assemble every page in order": {
+			total:       23,
+			pageSize:    5,
+			concurrency: 1,
+		},
+		"should assemble every page in order when fetched concurrently": {
+			total:       23,
+			pageSize:    5,
+			concurrency: 4,
+		},
+		"should fetch a final empty page when the total is a multiple of the page size": {
+			total:       20,
+			pageSize:    5,
+			concurrency: 3,
+		},
+		"should return no healthchecks when there are none": {
+			total:       0,
+			pageSize:    5,
+			concurrency: 2,
+		},
+		"a failed page should fail the whole fetch": {
+			total:       23,
+			pageSize:    5,
+			concurrency: 2,
+			failOffset:  10,
+			failErr:     errors.New("biz-ops API call failed"),
+			expectedErr: errors.New("biz-ops API call failed"),
+		},
+		"a page failing with graphql errors should keep the typed error": {
+			total:       23,
+			pageSize:    5,
+			concurrency: 2,
+			failOffset:  10,
+			failErr:     totalFailureError,
+			expectedErr: totalFailureError,
+		},
+		"a short page of partial data should fail the whole fetch": {
+			total:            23,
+			pageSize:         5,
+			concurrency:      2,
+			failOffset:       10,
+			failErr:          partialDataError,
+			allowPartialData: true,
+			expectedErr:      partialDataError,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			apiClient := &PagingAPIClient{
+				healthchecks: newHealthchecks(test.total),
+				failOffset:   test.failOffset,
+				failErr:      test.failErr,
+			}
+			serviceDiscovery := BizOps{
+				ApiClient:        apiClient,
+				PageSize:         test.pageSize,
+				PageConcurrency:  test.concurrency,
+				AllowPartialData: test.allowPartialData,
+			}
+
+			healthchecks, err := serviceDiscovery.fetchHealthchecks(context.Background())
+
+			for _, query := range apiClient.queries {
+				assert.Contains(t, query, "orderBy: code_asc", "Expected the pages to be in a stable order")
+			}
+			if test.expectedErr != nil {
+				require.IsType(t, &PageError{}, err, "Expected a page error")
+				assert.Equal(t, test.failOffset/test.pageSize, err.(*PageError).Index, "Expected the failed page")
+				assert.Equal(t, test.expectedErr, (&FetchError{Err: err}).Cause(), "Expected the client's error as the cause")
+				assert.Nil(t, healthchecks, "Expected no healthchecks to be returned")
+				return
+			}
+			require.NoError(t, err, "Error not expected")
+			assert.Equal(t, apiClient.healthchecks, healthchecks, "Expected every healthcheck to be returned in order")
+		})
+	}
+}
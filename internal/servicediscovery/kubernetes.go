@@ -0,0 +1,203 @@
+package servicediscovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// KindProbe writes each label group as a Prometheus Operator Probe probed through a prober such as blackbox exporter
+	KindProbe = "Probe"
+	// KindScrapeConfig writes each label group as a Prometheus Operator ScrapeConfig with a static config
+	KindScrapeConfig = "ScrapeConfig"
+)
+
+// managedByLabel marks the resources so they can be selected, e.g. to prune those for groups which have gone
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+const managedBy = "prometheus-biz-ops-service-discovery"
+
+// emptyResources is written when there are no groups, a comment which is read back as no resources
+const emptyResources = "# there are no health check targets\n"
+
+var invalidResourceNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// KubernetesEncoder writes the configuration as Prometheus Operator resources, one per label group,
+// in a multi-document YAML file which can be applied with kubectl
+type KubernetesEncoder struct {
+	// Kind of resource written, KindProbe or KindScrapeConfig
+	Kind string
+	// Namespace of the resources, left to kubectl if empty
+	Namespace string
+	// NamePrefix starts the name of every resource, which ends with a hash of the group's labels
+	NamePrefix string
+	// ProberURL the address of the prober which probes the targets, for Probes
+	ProberURL string
+	// Module the prober module used for the targets, for Probes
+	Module string
+}
+
+type resourceMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+type resource struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   resourceMetadata `yaml:"metadata"`
+	Spec       interface{}      `yaml:"spec"`
+}
+
+type staticConfig struct {
+	Static []string `yaml:"static"`
+	Labels labels   `yaml:"labels,omitempty"`
+}
+
+type probeSpec struct {
+	JobName string `yaml:"jobName"`
+	Prober  struct {
+		URL string `yaml:"url"`
+	} `yaml:"prober"`
+	Module  string `yaml:"module,omitempty"`
+	Targets struct {
+		StaticConfig staticConfig `yaml:"staticConfig"`
+	} `yaml:"targets"`
+}
+
+type scrapeConfigSpec struct {
+	StaticConfigs []prometheusConfiguration `yaml:"staticConfigs"`
+}
+
+// Validate checks the encoder can write its kind of resource
+func (encoder KubernetesEncoder) Validate() error {
+	switch encoder.Kind {
+	case KindProbe:
+		if encoder.ProberURL == "" {
+			return fmt.Errorf("a prober url is needed to write Probes")
+		}
+	case KindScrapeConfig:
+	default:
+		return fmt.Errorf("unknown resource kind %q, must be %s or %s", encoder.Kind, KindProbe, KindScrapeConfig)
+	}
+	if encoder.NamePrefix != "" && invalidResourceNameCharacters.MatchString(encoder.NamePrefix) {
+		return fmt.Errorf("name prefix %q must only contain lowercase letters, digits and -", encoder.NamePrefix)
+	}
+	return nil
+}
+
+// Extension is .yaml
+func (encoder KubernetesEncoder) Extension() string {
+	return ".yaml"
+}
+
+// name a stable name for the group's resource, made readable with its system label if it has one
+func (encoder KubernetesEncoder) name(groupLabels labels) string {
+	hash := sha256.Sum256([]byte(groupLabels.key()))
+	parts := []string{}
+	if encoder.NamePrefix != "" {
+		parts = append(parts, encoder.NamePrefix)
+	}
+	if system := strings.Trim(invalidResourceNameCharacters.ReplaceAllString(strings.ToLower(groupLabels["system"]), "-"), "-"); system != "" {
+		if len(system) > 40 {
+			system = system[:40]
+		}
+		parts = append(parts, system)
+	}
+	parts = append(parts, hex.EncodeToString(hash[:5]))
+	return strings.Join(parts, "-")
+}
+
+// Encode writes a resource for each group of the configuration, v must be the configuration
+func (encoder KubernetesEncoder) Encode(v interface{}) ([]byte, error) {
+	configuration, ok := v.([]prometheusConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("only the service discovery configuration can be written as %s resources", encoder.Kind)
+	}
+
+	var documents bytes.Buffer
+	for _, group := range configuration {
+		resource := resource{
+			Kind: encoder.Kind,
+			Metadata: resourceMetadata{
+				Name:      encoder.name(group.Labels),
+				Namespace: encoder.Namespace,
+				Labels:    map[string]string{managedByLabel: managedBy},
+			},
+		}
+		switch encoder.Kind {
+		case KindProbe:
+			spec := probeSpec{JobName: resource.Metadata.Name, Module: encoder.Module}
+			spec.Prober.URL = encoder.ProberURL
+			spec.Targets.StaticConfig = staticConfig{Static: group.Targets, Labels: group.Labels}
+			resource.APIVersion = "monitoring.coreos.com/v1"
+			resource.Spec = spec
+		case KindScrapeConfig:
+			resource.APIVersion = "monitoring.coreos.com/v1alpha1"
+			resource.Spec = scrapeConfigSpec{StaticConfigs: []prometheusConfiguration{group}}
+		default:
+			return nil, fmt.Errorf("unknown resource kind %q", encoder.Kind)
+		}
+
+		document, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		documents.WriteString("---\n")
+		documents.Write(document)
+	}
+	// the file is never left empty, which would look like a failed write
+	if documents.Len() == 0 {
+		documents.WriteString(emptyResources)
+	}
+	return documents.Bytes(), nil
+}
+
+// Decode reads the groups back from the resources, v must point to a configuration
+func (encoder KubernetesEncoder) Decode(content []byte, v interface{}) error {
+	configuration, ok := v.(*[]prometheusConfiguration)
+	if !ok {
+		return fmt.Errorf("%s resources can only be read as the service discovery configuration", encoder.Kind)
+	}
+
+	decoded := make([]prometheusConfiguration, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var document struct {
+			Kind string `yaml:"kind"`
+			Spec struct {
+				Targets struct {
+					StaticConfig staticConfig `yaml:"staticConfig"`
+				} `yaml:"targets"`
+				StaticConfigs []prometheusConfiguration `yaml:"staticConfigs"`
+			} `yaml:"spec"`
+		}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch document.Kind {
+		case KindProbe:
+			static := document.Spec.Targets.StaticConfig
+			decoded = append(decoded, prometheusConfiguration{Targets: static.Static, Labels: static.Labels})
+		case KindScrapeConfig:
+			decoded = append(decoded, document.Spec.StaticConfigs...)
+		default:
+			return fmt.Errorf("unexpected resource kind %q", document.Kind)
+		}
+	}
+	*configuration = decoded
+	return nil
+}
@@ -0,0 +1,105 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKubernetesEncoderEncode(t *testing.T) {
+	configuration := []prometheusConfiguration{
+		{Targets: []string{"https://a.ft.com/__health"}, Labels: labels{"observe": "yes", "system": "System_A"}},
+	}
+	testCases := map[string]struct {
+		encoder  KubernetesEncoder
+		expected string
+	}{
+		"probe": {
+			encoder: KubernetesEncoder{Kind: KindProbe, Namespace: "monitoring", NamePrefix: "biz-ops", ProberURL: "blackbox-exporter:9115", Module: "http_2xx"},
+			expected: `---
+apiVersion: monitoring.coreos.com/v1
+kind: Probe
+metadata:
+  name: biz-ops-system-a-%[1]s
+  namespace: monitoring
+  labels:
+    app.kubernetes.io/managed-by: prometheus-biz-ops-service-discovery
+spec:
+  jobName: biz-ops-system-a-%[1]s
+  prober:
+    url: blackbox-exporter:9115
+  module: http_2xx
+  targets:
+    staticConfig:
+      static:
+      - https://a.ft.com/__health
+      labels:
+        observe: "yes"
+        system: System_A
+`,
+		},
+		"scrape config": {
+			encoder: KubernetesEncoder{Kind: KindScrapeConfig},
+			expected: `---
+apiVersion: monitoring.coreos.com/v1alpha1
+kind: ScrapeConfig
+metadata:
+  name: system-a-%[1]s
+  labels:
+    app.kubernetes.io/managed-by: prometheus-biz-ops-service-discovery
+spec:
+  staticConfigs:
+  - targets:
+    - https://a.ft.com/__health
+    labels:
+      observe: "yes"
+      system: System_A
+`,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			hash := test.encoder.name(configuration[0].Labels)
+			hash = hash[len(hash)-10:]
+
+			content, err := test.encoder.Encode(configuration)
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf(test.expected, hash), string(content), "Resources were not as expected")
+
+			var decoded []prometheusConfiguration
+			require.NoError(t, test.encoder.Decode(content, &decoded))
+			assert.Equal(t, configuration, decoded, "Expected the groups to be read back from the resources")
+		})
+	}
+}
+
+func TestKubernetesEncoderEncodesNoGroups(t *testing.T) {
+	encoder := KubernetesEncoder{Kind: KindScrapeConfig}
+
+	content, err := encoder.Encode([]prometheusConfiguration{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, content, "Expected something to be written without any groups")
+
+	var decoded []prometheusConfiguration
+	require.NoError(t, encoder.Decode(content, &decoded))
+	assert.Empty(t, decoded, "Expected no groups to be read back")
+}
+
+func TestKubernetesEncoderNamesAreUnique(t *testing.T) {
+	encoder := KubernetesEncoder{Kind: KindScrapeConfig}
+	live := encoder.name(labels{"observe": "yes", "system": "a"})
+	notLive := encoder.name(labels{"observe": "no", "system": "a"})
+
+	assert.NotEqual(t, live, notLive, "Expected groups of the same system to have different names")
+	assert.Equal(t, live, encoder.name(labels{"observe": "yes", "system": "a"}), "Expected the name to be stable")
+}
+
+func TestKubernetesEncoderValidate(t *testing.T) {
+	assert.Error(t, KubernetesEncoder{Kind: KindProbe}.Validate(), "Expected a Probe without a prober to be invalid")
+	assert.Error(t, KubernetesEncoder{Kind: "ServiceMonitor"}.Validate(), "Expected an unknown kind to be invalid")
+	assert.Error(t, KubernetesEncoder{Kind: KindScrapeConfig, NamePrefix: "Biz_Ops"}.Validate(), "Expected an invalid name prefix to be invalid")
+	assert.NoError(t, KubernetesEncoder{Kind: KindScrapeConfig, NamePrefix: "biz-ops"}.Validate())
+}
@@ -0,0 +1,207 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labels the prometheus labels attached to a group of targets
+type labels map[string]string
+
+// key returns a stable string representation of the labels, used to group targets
+func (l labels) key() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, l[name]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labels) with(name string, value string) labels {
+	copied := make(labels, len(l)+1)
+	for k, v := range l {
+		copied[k] = v
+	}
+	copied[name] = value
+	return copied
+}
+
+// LabelMapping maps a field of a biz-ops healthcheck to a prometheus label.
+// Field is a dot separated path from the healthcheck, e.g. monitors.code, which
+// is added to the GraphQL query. Paths which pass through a list produce a
+// group of targets for each value.
+type LabelMapping struct {
+	Label     string
+	Field     string
+	Transform string
+}
+
+// DefaultLabelMappings the labels written when none are configured
+var DefaultLabelMappings = []LabelMapping{
+	{Label: "system", Field: "monitors.code"},
+	{Label: "observe", Field: "isLive", Transform: "yesno"},
+}
+
+var transforms = map[string]func(string) string{
+	"":      func(value string) string { return value },
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"yesno": func(value string) string {
+		if value == "true" {
+			return "yes"
+		}
+		return "no"
+	},
+}
+
+var (
+	labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	fieldNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+)
+
+// ParseLabelMapping parses a mapping given in the form label=field.path or label=field.path|transform
+func ParseLabelMapping(value string) (LabelMapping, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return LabelMapping{}, fmt.Errorf("label mapping %q is not in the form label=field.path[|transform]", value)
+	}
+	mapping := LabelMapping{Label: strings.TrimSpace(parts[0])}
+	fieldAndTransform := strings.SplitN(parts[1], "|", 2)
+	mapping.Field = strings.TrimSpace(fieldAndTransform[0])
+	if len(fieldAndTransform) == 2 {
+		mapping.Transform = strings.TrimSpace(fieldAndTransform[1])
+	}
+	return mapping, mapping.Validate()
+}
+
+// Validate checks the label name, field path and transform are all usable
+func (mapping LabelMapping) Validate() error {
+	if !labelNamePattern.MatchString(mapping.Label) || strings.HasPrefix(mapping.Label, "__") {
+		return fmt.Errorf("%q is not a valid prometheus label name", mapping.Label)
+	}
+	for _, field := range mapping.fieldPath() {
+		if !fieldNamePattern.MatchString(field) {
+			return fmt.Errorf("%q is not a valid biz-ops field path for label %q", mapping.Field, mapping.Label)
+		}
+	}
+	if _, ok := transforms[mapping.Transform]; !ok {
+		return fmt.Errorf("unknown transform %q for label %q", mapping.Transform, mapping.Label)
+	}
+	return nil
+}
+
+func (mapping LabelMapping) fieldPath() []string {
+	return strings.Split(mapping.Field, ".")
+}
+
+// values returns every transformed value of the mapped field in the given healthcheck fields
+func (mapping LabelMapping) values(fields map[string]interface{}) []string {
+	transform := transforms[mapping.Transform]
+	resolved := resolveFieldPath(fields, mapping.fieldPath())
+	if len(resolved) == 0 {
+		// a missing field is still transformed, so yesno writes "no" for a null or absent isLive
+		resolved = []string{""}
+	}
+	seen := map[string]bool{}
+	values := make([]string, 0)
+	for _, value := range resolved {
+		value = transform(value)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	return values
+}
+
+func resolveFieldPath(value interface{}, path []string) []string {
+	switch typed := value.(type) {
+	case []interface{}:
+		values := make([]string, 0)
+		for _, item := range typed {
+			values = append(values, resolveFieldPath(item, path)...)
+		}
+		return values
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return nil
+		}
+		return resolveFieldPath(typed[path[0]], path[1:])
+	case nil:
+		return nil
+	}
+
+	if len(path) > 0 {
+		return nil
+	}
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case bool:
+		return []string{strconv.FormatBool(typed)}
+	case float64:
+		return []string{strconv.FormatFloat(typed, 'f', -1, 64)}
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// labelSets returns the label sets for a healthcheck, one for each combination of mapped values.
+// Labels with no value after their transform are left off.
+func labelSets(mappings []LabelMapping, fields map[string]interface{}) []labels {
+	sets := []labels{labels{}}
+	for _, mapping := range mappings {
+		values := mapping.values(fields)
+		if len(values) == 0 {
+			continue
+		}
+		expanded := make([]labels, 0, len(sets)*len(values))
+		for _, set := range sets {
+			for _, value := range values {
+				expanded = append(expanded, set.with(mapping.Label, value))
+			}
+		}
+		sets = expanded
+	}
+	return sets
+}
+
+// fields returns the raw fields biz-ops returned for the healthcheck, falling
+// back to the typed fields if it was not unmarshalled from a response.
+func (healthcheck Healthcheck) fields() map[string]interface{} {
+	if healthcheck.Fields != nil {
+		return healthcheck.Fields
+	}
+	fields := map[string]interface{}{}
+	encoded, err := json.Marshal(healthcheck)
+	if err == nil {
+		_ = json.Unmarshal(encoded, &fields)
+	}
+	return fields
+}
+
+// UnmarshalJSON keeps the raw healthcheck fields alongside the typed ones so mapped labels can be resolved
+func (healthcheck *Healthcheck) UnmarshalJSON(data []byte) error {
+	type typedHealthcheck Healthcheck
+	var typed typedHealthcheck
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*healthcheck = Healthcheck(typed)
+	healthcheck.Fields = fields
+	return nil
+}
@@ -0,0 +1,117 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabelMapping(t *testing.T) {
+	testCases := map[string]struct {
+		value           string
+		expectedMapping LabelMapping
+		expectedErr     bool
+	}{
+		"field path should be mapped to the label": {
+			value:           "team=monitors.deliveredBy.code",
+			expectedMapping: LabelMapping{Label: "team", Field: "monitors.deliveredBy.code"},
+		},
+		"transform should be parsed": {
+			value:           "observe=isLive|yesno",
+			expectedMapping: LabelMapping{Label: "observe", Field: "isLive", Transform: "yesno"},
+		},
+		"missing field should return an error": {
+			value:       "team",
+			expectedErr: true,
+		},
+		"invalid label name should return an error": {
+			value:       "owning-team=monitors.deliveredBy.code",
+			expectedErr: true,
+		},
+		"reserved label name should return an error": {
+			value:       "__address__=url",
+			expectedErr: true,
+		},
+		"invalid field path should return an error": {
+			value:       "team=monitors.deliveredBy { code }",
+			expectedErr: true,
+		},
+		"unknown transform should return an error": {
+			value:       "team=monitors.deliveredBy.code|reverse",
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			mapping, err := ParseLabelMapping(test.value)
+			if test.expectedErr {
+				assert.Error(t, err, "Expected error was not returned")
+				return
+			}
+			require.NoError(t, err, "Error not expected")
+			assert.Equal(t, test.expectedMapping, mapping, "Parsed mapping was not as expected")
+		})
+	}
+}
+
+func TestLabelSets(t *testing.T) {
+	var healthcheck Healthcheck
+	err := json.Unmarshal([]byte(`{
+		"code": "system1.check",
+		"url": "https://system1.com/__health",
+		"isLive": true,
+		"serviceTier": "Gold",
+		"monitors": [
+			{"code": "system1", "deliveredBy": {"code": "Team-A"}},
+			{"code": "system2", "deliveredBy": {"code": "Team-A"}}
+		]
+	}`), &healthcheck)
+	require.NoError(t, err, "Error not expected unmarshalling the healthcheck")
+
+	mappings := []LabelMapping{
+		{Label: "system", Field: "monitors.code"},
+		{Label: "team", Field: "monitors.deliveredBy.code", Transform: "lower"},
+		{Label: "tier", Field: "serviceTier", Transform: "lower"},
+		{Label: "observe", Field: "isLive", Transform: "yesno"},
+		{Label: "lifecycle", Field: "lifecycleStage"},
+	}
+
+	assert.Equal(t, []labels{
+		{"system": "system1", "team": "team-a", "tier": "gold", "observe": "yes"},
+		{"system": "system2", "team": "team-a", "tier": "gold", "observe": "yes"},
+	}, labelSets(mappings, healthcheck.fields()), "Label sets were not as expected")
+}
+
+func TestLabelSetsTransformMissingFields(t *testing.T) {
+	var healthcheck Healthcheck
+	require.NoError(t, json.Unmarshal([]byte(`{"code": "check", "url": "https://url.com", "isLive": null, "monitors": []}`), &healthcheck))
+
+	assert.Equal(t, []labels{
+		{"observe": "no"},
+	}, labelSets(DefaultLabelMappings, healthcheck.fields()), "Expected a null isLive to be observe=no and a missing system to be left off")
+}
+
+func TestHealthchecksQueryIncludesMappedFields(t *testing.T) {
+	bizOps := BizOps{LabelMappings: []LabelMapping{
+		{Label: "system", Field: "monitors.code"},
+		{Label: "team", Field: "monitors.deliveredBy.code"},
+	}}
+	query := healthchecksQuery(bizOps.queryFieldPaths(), false)
+
+	assert.Equal(t, `query Healthchecks {
+  Healthchecks {
+    code
+    url
+    monitors {
+      code
+      deliveredBy {
+        code
+      }
+    }
+  }
+}`, query, "Query was not as expected")
+}
@@ -0,0 +1,112 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ManifestFilename the hidden file in the output directory listing the files the configuration was
+// last written to, so those no longer written can be removed without touching any other file
+const ManifestFilename = ".health-check-service-discovery.manifest"
+
+// directoryWriter is implemented by writers which can write and remove other files in their directory
+type directoryWriter interface {
+	filenameWriter
+	Remove(filename string) error
+}
+
+// readManifest returns the files listed in the manifest, nil if there isn't one
+func readManifest(writer directoryWriter) []string {
+	content := lastWritten(writer.WithFilename(ManifestFilename))
+	if content == nil {
+		return nil
+	}
+	var filenames []string
+	if err := json.Unmarshal(content, &filenames); err != nil {
+		log.WithFields(log.Fields{
+			"event": "ERROR_PARSING_MANIFEST",
+			"err":   err,
+		}).Warn("Could not parse the manifest of written files, no stale files will be removed.")
+		return nil
+	}
+	return filenames
+}
+
+// staleOutputs returns the manifest and the files it lists which are no longer written, with what they last contained
+func (bizOps *BizOps) staleOutputs(outputs []output) ([]string, []output) {
+	writer, ok := bizOps.Writer.(directoryWriter)
+	if !ok {
+		return nil, nil
+	}
+	manifest := readManifest(writer)
+
+	current := map[string]bool{}
+	for _, out := range outputs {
+		current[out.filename] = true
+	}
+	stale := make([]output, 0)
+	for _, filename := range manifest {
+		if current[filename] || filename == ManifestFilename {
+			continue
+		}
+		out := output{name: filename, filename: filename, writer: writer.WithFilename(filename)}
+		out.load(bizOps.withExporter(EncoderForFile(filename)))
+		stale = append(stale, out)
+	}
+	return manifest, stale
+}
+
+// removeOutput removes a file which is no longer written
+func (bizOps *BizOps) removeOutput(out output) error {
+	writer := bizOps.Writer.(directoryWriter)
+	if err := writer.Remove(out.filename); err != nil {
+		return fmt.Errorf("failed to remove the stale file %s (%v)", out.filename, err)
+	}
+	log.WithFields(log.Fields{
+		"event":       "OUTPUT_REMOVED",
+		"file":        out.filename,
+		"targetCount": countTargets(out.previous),
+	}).Info("A file which is no longer written has been removed.")
+	return nil
+}
+
+// writeManifest records the files the configuration was written to, if they have changed
+func (bizOps *BizOps) writeManifest(manifest []string, outputs []output) error {
+	writer, ok := bizOps.Writer.(directoryWriter)
+	if !ok {
+		return nil
+	}
+
+	filenames := make([]string, 0, len(outputs))
+	for _, out := range outputs {
+		filenames = append(filenames, out.filename)
+	}
+	sort.Strings(filenames)
+	if sameFilenames(manifest, filenames) {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(filenames, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := writer.WithFilename(ManifestFilename).Write(content); err != nil {
+		return fmt.Errorf("failed to write the manifest (%v)", err)
+	}
+	return nil
+}
+
+func sameFilenames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
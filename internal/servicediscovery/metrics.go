@@ -0,0 +1,102 @@
+package servicediscovery
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Runs the number of service discovery runs which completed, successfully or not
+var Runs = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_runs_total",
+		Help: "Number of completed service discovery runs",
+	},
+)
+
+// Writes the number of runs which left the configuration up to date
+var Writes = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_writes_total",
+		Help: "Number of successful service discovery runs",
+	},
+)
+
+// Failures the number of runs which failed
+var Failures = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_failures_total",
+		Help: "Number of service discovery failures",
+	},
+)
+
+// LastSuccessTimestamp when the last successful run finished
+var LastSuccessTimestamp = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_last_success_timestamp_seconds",
+		Help: "Unix time at which the last successful service discovery run finished",
+	},
+)
+
+// PhaseDuration the time taken by each phase of a run
+var PhaseDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "service_discovery_run_phase_duration_seconds",
+		Help:    "Duration of each phase of a service discovery run: fetch, transform or write",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	},
+	[]string{"phase"},
+)
+
+// GroupTargets the number of targets written for each system and observe label
+var GroupTargets = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_targets",
+		Help: "Number of targets in the service discovery configuration by system and observe label",
+	},
+	[]string{"system", "observe"},
+)
+
+// InvalidURLs the number of healthchecks dropped in the last run as their URL could not be parsed
+var InvalidURLs = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_invalid_url_healthchecks",
+		Help: "Number of healthchecks dropped in the last service discovery run as their URL was invalid",
+	},
+)
+
+// RegisterMetrics registers the service discovery metrics with the given registerer
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(
+		Runs,
+		Writes,
+		Failures,
+		LastSuccessTimestamp,
+		PhaseDuration,
+		GroupTargets,
+		InvalidURLs,
+		FilteredTargets,
+		WriteBlocked,
+		ChangedWrites,
+		UnchangedRuns,
+		TargetsAdded,
+		TargetsRemoved,
+		TargetsRelabelled,
+		SourceTargets,
+		ActiveOverrides,
+		ShardTargets,
+		PartitionTargets,
+	)
+}
+
+func observePhase(phase string, start time.Time) {
+	PhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// recordGroups sets the number of targets for each system and observe label from the configuration
+func recordGroups(configuration []prometheusConfiguration) {
+	GroupTargets.Reset()
+	for _, group := range configuration {
+		GroupTargets.WithLabelValues(group.Labels["system"], group.Labels["observe"]).Add(float64(len(group.Targets)))
+	}
+}
@@ -0,0 +1,37 @@
+package servicediscovery
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMetrics(t *testing.T) {
+	PhaseDuration.Reset()
+	serviceDiscovery := BizOps{
+		Writer: &RecordingWriter{},
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				Healthcheck{URL: "https://url.com", IsLive: true, Systems: []System{System{SystemCode: "system-a"}}},
+				Healthcheck{URL: "https://url2.com", IsLive: true, Systems: []System{System{SystemCode: "system-a"}}},
+				Healthcheck{URL: "https://url3.com", IsLive: false, Systems: []System{System{SystemCode: "system-b"}}},
+				Healthcheck{URL: "not a url", IsLive: true},
+			}),
+		},
+	}
+
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(InvalidURLs), "Expected the invalid URL to be counted")
+	assert.Equal(t, float64(2), testutil.ToFloat64(GroupTargets.WithLabelValues("system-a", "yes")), "Expected the targets in the group to be counted")
+	assert.Equal(t, float64(1), testutil.ToFloat64(GroupTargets.WithLabelValues("system-b", "no")), "Expected the targets in the group to be counted")
+	assert.Equal(t, 3, testutil.CollectAndCount(PhaseDuration), "Expected each phase to be timed")
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.NotPanics(t, func() { RegisterMetrics(registry) }, "Expected the metrics to register")
+}
@@ -0,0 +1,255 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+)
+
+// OverridesFilename the default name of the overrides file in the output directory
+const OverridesFilename = "overrides.yaml"
+
+// ActiveOverrides the number of unexpired overrides applied in the last run, by kind
+var ActiveOverrides = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_active_overrides",
+		Help: "Number of unexpired manual overrides applied in the last service discovery run by kind: exclude, add or relabel",
+	},
+	[]string{"kind"},
+)
+
+// Overrides manual changes to the discovered targets, for when editing biz-ops isn't practical.
+// Each entry stops applying once its optional expiry has passed.
+type Overrides struct {
+	// Exclude targets by URL or labels
+	Exclude []ExcludeOverride `yaml:"exclude"`
+	// Add targets with labels, replacing any discovered label sets for the same URL
+	Add []AddOverride `yaml:"add"`
+	// Relabel sets labels on every label set of a target, an empty value removes the label
+	Relabel []RelabelOverride `yaml:"relabel"`
+}
+
+// ExcludeOverride drops the target with the URL, or every target with all of the labels
+type ExcludeOverride struct {
+	URL     string            `yaml:"url"`
+	Labels  map[string]string `yaml:"labels"`
+	Reason  string            `yaml:"reason"`
+	Expires time.Time         `yaml:"expires"`
+}
+
+// matches whether the override excludes the target
+func (exclude ExcludeOverride) matches(target Target) bool {
+	if exclude.URL != "" {
+		return target.URL == exclude.URL
+	}
+	for name, value := range exclude.Labels {
+		if target.Labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// describe the targets the override excludes, for logs
+func (exclude ExcludeOverride) describe() string {
+	if exclude.URL != "" {
+		return exclude.URL
+	}
+	return labels(exclude.Labels).key()
+}
+
+// AddOverride writes a target with the labels
+type AddOverride struct {
+	URL     string            `yaml:"url"`
+	Labels  map[string]string `yaml:"labels"`
+	Reason  string            `yaml:"reason"`
+	Expires time.Time         `yaml:"expires"`
+}
+
+// RelabelOverride changes the labels of the target with the URL
+type RelabelOverride struct {
+	URL     string            `yaml:"url"`
+	Labels  map[string]string `yaml:"labels"`
+	Reason  string            `yaml:"reason"`
+	Expires time.Time         `yaml:"expires"`
+}
+
+func expired(expires time.Time, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+func validateOverrideLabels(kind string, index int, overrideLabels map[string]string) error {
+	for name := range overrideLabels {
+		if !labelNamePattern.MatchString(name) {
+			return fmt.Errorf("%s override %d has an invalid label name %q", kind, index, name)
+		}
+	}
+	return nil
+}
+
+// Validate checks every override is usable
+func (overrides Overrides) Validate() error {
+	for index, exclude := range overrides.Exclude {
+		if (exclude.URL == "") == (len(exclude.Labels) == 0) {
+			return fmt.Errorf("exclude override %d must have either a url or labels", index)
+		}
+		if err := validateOverrideLabels("exclude", index, exclude.Labels); err != nil {
+			return err
+		}
+	}
+	for index, add := range overrides.Add {
+		if _, err := url.ParseRequestURI(add.URL); err != nil {
+			return fmt.Errorf("add override %d has an invalid url %q", index, add.URL)
+		}
+		if err := validateOverrideLabels("add", index, add.Labels); err != nil {
+			return err
+		}
+	}
+	for index, relabel := range overrides.Relabel {
+		if relabel.URL == "" {
+			return fmt.Errorf("relabel override %d must have a url", index)
+		}
+		if err := validateOverrideLabels("relabel", index, relabel.Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// active returns the overrides which have not expired, logging those which have
+func (overrides Overrides) active(now time.Time) Overrides {
+	logExpired := func(kind string, target string, reason string, expires time.Time) {
+		log.WithFields(log.Fields{
+			"event":   "OVERRIDE_EXPIRED",
+			"kind":    kind,
+			"target":  target,
+			"reason":  reason,
+			"expires": expires.Format(time.RFC3339),
+		}).Warn("An override has expired and is being ignored, it can be removed from the overrides file.")
+	}
+
+	var active Overrides
+	for _, exclude := range overrides.Exclude {
+		if expired(exclude.Expires, now) {
+			logExpired("exclude", exclude.describe(), exclude.Reason, exclude.Expires)
+			continue
+		}
+		active.Exclude = append(active.Exclude, exclude)
+	}
+	for _, add := range overrides.Add {
+		if expired(add.Expires, now) {
+			logExpired("add", add.URL, add.Reason, add.Expires)
+			continue
+		}
+		active.Add = append(active.Add, add)
+	}
+	for _, relabel := range overrides.Relabel {
+		if expired(relabel.Expires, now) {
+			logExpired("relabel", relabel.URL, relabel.Reason, relabel.Expires)
+			continue
+		}
+		active.Relabel = append(active.Relabel, relabel)
+	}
+	return active
+}
+
+// apply excludes, adds and then relabels the targets using the overrides which are active at the given time
+func (overrides Overrides) apply(targets []Target, now time.Time) []Target {
+	active := overrides.active(now)
+	ActiveOverrides.WithLabelValues("exclude").Set(float64(len(active.Exclude)))
+	ActiveOverrides.WithLabelValues("add").Set(float64(len(active.Add)))
+	ActiveOverrides.WithLabelValues("relabel").Set(float64(len(active.Relabel)))
+
+	excluded := func(target Target) bool {
+		for _, exclude := range active.Exclude {
+			if exclude.matches(target) {
+				return true
+			}
+		}
+		return false
+	}
+	addedURLs := map[string]bool{}
+	for _, add := range active.Add {
+		addedURLs[add.URL] = true
+	}
+
+	overridden := make([]Target, 0, len(targets)+len(active.Add))
+	for _, target := range targets {
+		if excluded(target) {
+			log.WithFields(log.Fields{
+				"event":  "TARGET_EXCLUDED_BY_OVERRIDE",
+				"url":    target.URL,
+				"labels": target.Labels,
+			}).Debug("A target was excluded by an override.")
+			continue
+		}
+		if addedURLs[target.URL] {
+			continue
+		}
+		overridden = append(overridden, target)
+	}
+	for _, add := range active.Add {
+		overridden = append(overridden, Target{URL: add.URL, Labels: add.Labels})
+	}
+
+	for _, relabel := range active.Relabel {
+		for i, target := range overridden {
+			if target.URL != relabel.URL {
+				continue
+			}
+			relabelled := map[string]string{}
+			for name, value := range target.Labels {
+				relabelled[name] = value
+			}
+			for name, value := range relabel.Labels {
+				if value == "" {
+					delete(relabelled, name)
+				} else {
+					relabelled[name] = value
+				}
+			}
+			overridden[i].Labels = relabelled
+		}
+	}
+	return overridden
+}
+
+// OverridesFile reads the overrides from a YAML file on every run
+type OverridesFile struct {
+	Path string
+	fs   afero.Fs
+}
+
+// NewOverridesFile returns the overrides in the file, using the OS filesystem if fs is nil
+func NewOverridesFile(path string, fs afero.Fs) *OverridesFile {
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+	return &OverridesFile{Path: path, fs: fs}
+}
+
+// Load reads and validates the overrides, a missing file has none
+func (file *OverridesFile) Load() (Overrides, error) {
+	content, err := afero.ReadFile(file.fs, file.Path)
+	if os.IsNotExist(err) {
+		return Overrides{}, nil
+	}
+	if err != nil {
+		return Overrides{}, fmt.Errorf("failed to read the overrides file %s (%v)", file.Path, err)
+	}
+
+	var overrides Overrides
+	if err := yaml.UnmarshalStrict(content, &overrides); err != nil {
+		return Overrides{}, fmt.Errorf("failed to parse the overrides file %s (%v)", file.Path, err)
+	}
+	if err := overrides.Validate(); err != nil {
+		return Overrides{}, fmt.Errorf("invalid overrides file %s (%v)", file.Path, err)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,149 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverridesApply(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	targets := []Target{
+		{URL: "https://one.com", Labels: map[string]string{"system": "system-a", "observe": "yes"}},
+		{URL: "https://two.com", Labels: map[string]string{"system": "system-b", "observe": "yes"}},
+		{URL: "https://three.com", Labels: map[string]string{"system": "system-c", "observe": "yes"}},
+	}
+
+	testCases := map[string]struct {
+		overrides       Overrides
+		expectedTargets []Target
+		expectedActive  map[string]float64
+	}{
+		"targets should be excluded by url or labels": {
+			overrides: Overrides{
+				Exclude: []ExcludeOverride{
+					{URL: "https://one.com"},
+					{Labels: map[string]string{"system": "system-b", "observe": "yes"}, Expires: future},
+					{Labels: map[string]string{"system": "system-c", "observe": "no"}},
+				},
+			},
+			expectedTargets: targets[2:],
+			expectedActive:  map[string]float64{"exclude": 3, "add": 0, "relabel": 0},
+		},
+		"expired overrides should be ignored": {
+			overrides: Overrides{
+				Exclude: []ExcludeOverride{{URL: "https://one.com", Expires: past}},
+				Relabel: []RelabelOverride{{URL: "https://two.com", Labels: map[string]string{"observe": "no"}, Expires: now}},
+			},
+			expectedTargets: targets,
+			expectedActive:  map[string]float64{"exclude": 0, "add": 0, "relabel": 0},
+		},
+		"added targets should replace discovered ones": {
+			overrides: Overrides{
+				Add: []AddOverride{
+					{URL: "https://one.com", Labels: map[string]string{"system": "system-z"}},
+					{URL: "https://four.com", Labels: map[string]string{"system": "extra"}},
+				},
+			},
+			expectedTargets: []Target{
+				targets[1],
+				targets[2],
+				{URL: "https://one.com", Labels: map[string]string{"system": "system-z"}},
+				{URL: "https://four.com", Labels: map[string]string{"system": "extra"}},
+			},
+			expectedActive: map[string]float64{"exclude": 0, "add": 2, "relabel": 0},
+		},
+		"relabelling should set and remove labels": {
+			overrides: Overrides{
+				Relabel: []RelabelOverride{{URL: "https://two.com", Labels: map[string]string{"observe": "no", "system": "", "team": "a"}}},
+			},
+			expectedTargets: []Target{
+				targets[0],
+				{URL: "https://two.com", Labels: map[string]string{"observe": "no", "team": "a"}},
+				targets[2],
+			},
+			expectedActive: map[string]float64{"exclude": 0, "add": 0, "relabel": 1},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			assert.Equal(t, test.expectedTargets, test.overrides.apply(targets, now), "Targets were not as expected")
+			for kind, expected := range test.expectedActive {
+				assert.Equal(t, expected, testutil.ToFloat64(ActiveOverrides.WithLabelValues(kind)), "Active %s overrides were not as expected", kind)
+			}
+		})
+	}
+}
+
+func TestOverridesFileLoad(t *testing.T) {
+	testCases := map[string]struct {
+		content     string
+		expected    Overrides
+		expectedErr string
+	}{
+		"missing file should have no overrides": {
+			expected: Overrides{},
+		},
+		"overrides should be parsed": {
+			content: `
+exclude:
+  - url: https://one.com
+    reason: decommissioning
+    expires: 2026-11-01T00:00:00Z
+add:
+  - url: https://four.com
+    labels:
+      system: extra
+relabel:
+  - url: https://two.com
+    labels:
+      observe: "no"
+`,
+			expected: Overrides{
+				Exclude: []ExcludeOverride{{URL: "https://one.com", Reason: "decommissioning", Expires: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)}},
+				Add:     []AddOverride{{URL: "https://four.com", Labels: map[string]string{"system": "extra"}}},
+				Relabel: []RelabelOverride{{URL: "https://two.com", Labels: map[string]string{"observe": "no"}}},
+			},
+		},
+		"exclude without a url or labels should fail": {
+			content:     "exclude:\n  - reason: oops\n",
+			expectedErr: "exclude override 0 must have either a url or labels",
+		},
+		"add with an invalid url should fail": {
+			content:     "add:\n  - url: nope\n",
+			expectedErr: `add override 0 has an invalid url "nope"`,
+		},
+		"unknown keys should fail": {
+			content:     "remove:\n  - url: https://one.com\n",
+			expectedErr: "failed to parse the overrides file",
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if test.content != "" {
+				require.NoError(t, afero.WriteFile(fs, "/overrides.yaml", []byte(test.content), 0644))
+			}
+
+			overrides, err := NewOverridesFile("/overrides.yaml", fs).Load()
+
+			if test.expectedErr != "" {
+				require.Error(t, err, "Expected error was not returned")
+				assert.Contains(t, err.Error(), test.expectedErr, "Error was not as expected")
+				return
+			}
+			require.NoError(t, err, "Error not expected")
+			assert.Equal(t, test.expected, overrides, "Overrides were not as expected")
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PartitionValuePlaceholder is replaced by the label value in a partition filename template
+const PartitionValuePlaceholder = "{value}"
+
+// DefaultPartitionFilenameTemplate the default filename of each partition
+const DefaultPartitionFilenameTemplate = "health-check-service-discovery-" + PartitionValuePlaceholder + ".json"
+
+// DefaultUnmatchedPartition the default partition for targets without the label
+const DefaultUnmatchedPartition = "unmatched"
+
+// PartitionTargets the number of distinct targets written to each partition in the last run
+var PartitionTargets = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_partition_targets",
+		Help: "Number of targets written to each partition of the service discovery configuration",
+	},
+	[]string{"partition"},
+)
+
+var unsafeFilenameCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// Partitioning writes a file for each value of a label, so each team's Prometheus only reads its own targets
+type Partitioning struct {
+	// Label the targets are partitioned by
+	Label string
+	// FilenameTemplate the name of each partition's file, containing PartitionValuePlaceholder
+	FilenameTemplate string
+	// Unmatched the partition for targets without the label
+	Unmatched string
+}
+
+// Validate checks the partitioning is usable
+func (partitioning Partitioning) Validate() error {
+	if !labelNamePattern.MatchString(partitioning.Label) {
+		return fmt.Errorf("partition label %q is not a valid label name", partitioning.Label)
+	}
+	if strings.Count(partitioning.FilenameTemplate, PartitionValuePlaceholder) != 1 {
+		return fmt.Errorf("partition filename %q must contain %s once", partitioning.FilenameTemplate, PartitionValuePlaceholder)
+	}
+	if strings.HasPrefix(partitioning.FilenameTemplate, PartitionValuePlaceholder) {
+		return fmt.Errorf("partition filename %q must start with a fixed prefix before %s", partitioning.FilenameTemplate, PartitionValuePlaceholder)
+	}
+	if strings.ContainsAny(partitioning.FilenameTemplate, `/\*?[`) {
+		return fmt.Errorf("partition filename %q must be a plain filename", partitioning.FilenameTemplate)
+	}
+	if partitioning.Unmatched == "" {
+		return fmt.Errorf("the unmatched partition must be named")
+	}
+	return nil
+}
+
+// partition the name of the partition a label set belongs to, safe to use in a filename
+func (partitioning Partitioning) partition(groupLabels labels) string {
+	value, ok := groupLabels[partitioning.Label]
+	if !ok || value == "" {
+		value = partitioning.Unmatched
+	}
+	return unsafeFilenameCharacters.ReplaceAllString(value, "_")
+}
+
+// filename the name of the partition's file
+func (partitioning Partitioning) filename(partition string) string {
+	return strings.Replace(partitioning.FilenameTemplate, PartitionValuePlaceholder, partition, 1)
+}
+
+// partitionConfiguration splits the configuration by the label, keeping the groups in the same order
+func (partitioning Partitioning) partitionConfiguration(configuration []prometheusConfiguration) map[string][]prometheusConfiguration {
+	partitioned := map[string][]prometheusConfiguration{}
+	for _, group := range configuration {
+		partition := partitioning.partition(group.Labels)
+		partitioned[partition] = append(partitioned[partition], group)
+	}
+	return partitioned
+}
+
+// partitioning returns the partitioning with the filename template's extension following the format
+func (bizOps *BizOps) partitioning() Partitioning {
+	partitioning := *bizOps.Partition
+	partitioning.FilenameTemplate = withExtension(partitioning.FilenameTemplate, bizOps.encoder())
+	return partitioning
+}
+
+// partitionOutputs returns an output for each partition of the configuration, ordered by name
+func (bizOps *BizOps) partitionOutputs(configuration []prometheusConfiguration) ([]output, error) {
+	writer, ok := bizOps.Writer.(directoryWriter)
+	if !ok {
+		return nil, fmt.Errorf("the configuration writer does not support writing partitions")
+	}
+
+	partitioning := bizOps.partitioning()
+	partitioned := partitioning.partitionConfiguration(configuration)
+	partitions := make([]string, 0, len(partitioned))
+	for partition := range partitioned {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+
+	// reset so the series of partitions which have vanished aren't left behind
+	PartitionTargets.Reset()
+	outputs := make([]output, 0, len(partitions))
+	for _, partition := range partitions {
+		PartitionTargets.WithLabelValues(partition).Set(float64(countTargets(partitioned[partition])))
+		filename := partitioning.filename(partition)
+		outputs = append(outputs, output{
+			name:          "partition-" + partition,
+			filename:      filename,
+			writer:        writer.WithFilename(filename),
+			configuration: partitioned[partition],
+		})
+	}
+	return outputs, nil
+}
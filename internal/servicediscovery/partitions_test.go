@@ -0,0 +1,135 @@
+package servicediscovery
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func systemHealthcheck(url string, system string) Healthcheck {
+	check := Healthcheck{URL: url, IsLive: true}
+	if system != "" {
+		check.Systems = []System{{SystemCode: system}}
+	}
+	return check
+}
+
+func TestRunWritesPartitions(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	apiClient := &MockAPIClient{response: newGraphQLResponse([]Healthcheck{
+		systemHealthcheck("https://a.ft.com", "system-a"),
+		systemHealthcheck("https://b.ft.com", "system-b"),
+		systemHealthcheck("https://other.ft.com", ""),
+	})}
+	serviceDiscovery := BizOps{
+		Writer:    NewFileWriter("/etc/prometheus", memoryFS),
+		ApiClient: apiClient,
+		Partition: &Partitioning{Label: "system", FilenameTemplate: "targets-{value}.json", Unmatched: "unmatched"},
+	}
+
+	_, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+
+	expected := map[string]string{
+		"targets-system-a.json":  "https://a.ft.com",
+		"targets-system-b.json":  "https://b.ft.com",
+		"targets-unmatched.json": "https://other.ft.com",
+	}
+	for filename, target := range expected {
+		content, err := afero.ReadFile(memoryFS, filepath.Join("/etc/prometheus", filename))
+		require.NoError(t, err, "Expected %s to be written", filename)
+		configuration := parseConfiguration(content, JSONEncoder{})
+		require.Len(t, configuration, 1, "Expected one group in %s", filename)
+		assert.Equal(t, []string{target}, configuration[0].Targets, "Expected only the partition's targets in %s", filename)
+	}
+	exists, _ := afero.Exists(memoryFS, filepath.Join("/etc/prometheus", Filename))
+	assert.False(t, exists, "Expected no combined file")
+
+	apiClient.response = newGraphQLResponse([]Healthcheck{
+		systemHealthcheck("https://a.ft.com", "system-a"),
+		systemHealthcheck("https://other.ft.com", ""),
+	})
+	result, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+
+	exists, _ = afero.Exists(memoryFS, filepath.Join("/etc/prometheus", "targets-system-b.json"))
+	assert.False(t, exists, "Expected the vanished partition to be removed")
+	assert.True(t, result.Changed, "Expected removing a partition to be a change")
+	require.Len(t, result.Diff.Removed, 1, "Expected the vanished partition's target to be removed")
+	assert.Equal(t, "https://b.ft.com", result.Diff.Removed[0].Target)
+}
+
+func TestPartitioningValidate(t *testing.T) {
+	testCases := map[string]struct {
+		partitioning Partitioning
+		valid        bool
+	}{
+		"default template": {
+			partitioning: Partitioning{Label: "team", FilenameTemplate: DefaultPartitionFilenameTemplate, Unmatched: DefaultUnmatchedPartition},
+			valid:        true,
+		},
+		"missing placeholder": {
+			partitioning: Partitioning{Label: "team", FilenameTemplate: "targets.json", Unmatched: "unmatched"},
+		},
+		"no prefix before the placeholder": {
+			partitioning: Partitioning{Label: "team", FilenameTemplate: "{value}.json", Unmatched: "unmatched"},
+		},
+		"path in template": {
+			partitioning: Partitioning{Label: "team", FilenameTemplate: "teams/{value}.json", Unmatched: "unmatched"},
+		},
+		"invalid label": {
+			partitioning: Partitioning{Label: "team-name", FilenameTemplate: DefaultPartitionFilenameTemplate, Unmatched: "unmatched"},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			err := test.partitioning.Validate()
+			assert.Equal(t, test.valid, err == nil, "Validation was not as expected (%v)", err)
+		})
+	}
+}
+
+func TestRunGuardsPartitions(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	checks := []Healthcheck{
+		systemHealthcheck("https://a.ft.com", "system-a"),
+		systemHealthcheck("https://b.ft.com", "system-b"),
+		systemHealthcheck("https://c.ft.com", "system-c"),
+		systemHealthcheck("https://d.ft.com", "system-d"),
+	}
+	apiClient := &MockAPIClient{response: newGraphQLResponse(checks)}
+	serviceDiscovery := BizOps{
+		Writer:    NewFileWriter("/etc/prometheus", memoryFS),
+		ApiClient: apiClient,
+		Partition: &Partitioning{Label: "system", FilenameTemplate: "targets-{value}.json", Unmatched: "unmatched"},
+		Guard:     BlastRadiusGuard{MaxDropPercent: 50},
+	}
+	_, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+
+	// the label has gone, moving every target into the unmatched partition
+	apiClient.response = newGraphQLResponse([]Healthcheck{
+		systemHealthcheck("https://a.ft.com", ""),
+		systemHealthcheck("https://b.ft.com", ""),
+		systemHealthcheck("https://c.ft.com", ""),
+		systemHealthcheck("https://d.ft.com", ""),
+	})
+	_, err = serviceDiscovery.Run(context.Background())
+	assert.Error(t, err, "Expected the run to be blocked as every partition vanished")
+	for _, system := range []string{"system-a", "system-b", "system-c", "system-d"} {
+		exists, _ := afero.Exists(memoryFS, filepath.Join("/etc/prometheus", "targets-"+system+".json"))
+		assert.True(t, exists, "Expected the partition %s to be kept", system)
+	}
+
+	serviceDiscovery.Guard.Force = true
+	_, err = serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Expected a forced run to move the targets")
+	exists, _ := afero.Exists(memoryFS, filepath.Join("/etc/prometheus", "targets-system-a.json"))
+	assert.False(t, exists, "Expected the vanished partition to be removed once forced")
+}
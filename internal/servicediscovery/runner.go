@@ -0,0 +1,85 @@
+package servicediscovery
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrRunnerStopped is returned for a run requested once the runner has started waiting to stop
+var ErrRunnerStopped = errors.New("service discovery has stopped")
+
+// RunFunc performs a single service discovery run
+type RunFunc func(context.Context) (Result, error)
+
+type runCall struct {
+	done   chan struct{}
+	result Result
+	err    error
+}
+
+// Runner coalesces concurrent requests for a service discovery run, from the
+// background ticker or a manual refresh, so only one run happens at a time
+// and every caller waiting on it is given its outcome.
+type Runner struct {
+	ctx     context.Context
+	run     RunFunc
+	mutex   sync.Mutex
+	current *runCall
+	// stopped is set by Wait, after which no run is started, so running is never added to while it is waited on
+	stopped bool
+	running sync.WaitGroup
+}
+
+// NewRunner returns a Runner whose runs are cancelled with the given context
+func NewRunner(ctx context.Context, run RunFunc) *Runner {
+	return &Runner{ctx: ctx, run: run}
+}
+
+// Run starts a run, or joins the one in progress, and waits for its outcome.
+// Cancelling ctx stops the caller waiting but does not cancel the run. No run is
+// started once the runner's context is cancelled or Wait has been called.
+func (runner *Runner) Run(ctx context.Context) (Result, error) {
+	runner.mutex.Lock()
+	call := runner.current
+	if call == nil && (runner.stopped || runner.ctx.Err() != nil) {
+		runner.mutex.Unlock()
+		if err := runner.ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		return Result{}, ErrRunnerStopped
+	}
+	if call == nil {
+		call = &runCall{done: make(chan struct{})}
+		runner.current = call
+		runner.running.Add(1)
+		go runner.do(call)
+	}
+	runner.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+func (runner *Runner) do(call *runCall) {
+	defer runner.running.Done()
+
+	call.result, call.err = runner.run(runner.ctx)
+
+	runner.mutex.Lock()
+	runner.current = nil
+	runner.mutex.Unlock()
+	close(call.done)
+}
+
+// Wait stops any more runs from starting and blocks until the run in progress, if any, has finished
+func (runner *Runner) Wait() {
+	runner.mutex.Lock()
+	runner.stopped = true
+	runner.mutex.Unlock()
+	runner.running.Wait()
+}
@@ -0,0 +1,85 @@
+package servicediscovery
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunnerCoalescesConcurrentRuns(t *testing.T) {
+	var runs int32
+	release := make(chan struct{})
+	runner := NewRunner(context.Background(), func(ctx context.Context) (Result, error) {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return Result{Changed: true}, nil
+	})
+
+	var wg sync.WaitGroup
+	results := make([]Result, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = runner.Run(context.Background())
+		}(i)
+	}
+
+	// give every caller time to join the run before it finishes
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs), "Expected concurrent callers to share a single run")
+	for _, result := range results {
+		assert.True(t, result.Changed, "Expected every caller to be given the outcome of the run")
+	}
+
+	_, _ = runner.Run(context.Background())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runs), "Expected a new run once the previous one finished")
+}
+
+func TestRunnerCallerCancellationDoesNotCancelTheRun(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	runner := NewRunner(context.Background(), func(ctx context.Context) (Result, error) {
+		<-release
+		finished <- ctx.Err()
+		return Result{}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := runner.Run(ctx)
+	assert.Equal(t, context.Canceled, err, "Expected the caller to stop waiting")
+
+	close(release)
+	runner.Wait()
+	assert.NoError(t, <-finished, "Expected the run to carry on")
+}
+
+func TestRunnerRefusesRunsOnceStopped(t *testing.T) {
+	var runs int32
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := NewRunner(ctx, func(ctx context.Context) (Result, error) {
+		atomic.AddInt32(&runs, 1)
+		return Result{}, nil
+	})
+
+	cancel()
+	_, err := runner.Run(context.Background())
+	assert.Equal(t, context.Canceled, err, "Expected no run once the runner's context is cancelled")
+
+	runner = NewRunner(context.Background(), func(ctx context.Context) (Result, error) {
+		atomic.AddInt32(&runs, 1)
+		return Result{}, nil
+	})
+	runner.Wait()
+	_, err = runner.Run(context.Background())
+	assert.Equal(t, ErrRunnerStopped, err, "Expected no run once the runner is waiting to stop")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&runs), "Expected nothing to have run")
+}
@@ -1,26 +1,48 @@
 package servicediscovery
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"net/url"
+	"sort"
+	"time"
 
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/api"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 type graphQlClient interface {
-	Query(string, interface{}) error
+	Execute(context.Context, api.Request, interface{}) error
 }
 
-type labels struct {
-	System  string `json:"system,omitempty"`
-	Observe string `json:"observe,omitempty"`
+type prometheusConfiguration struct {
+	Targets []string `json:"targets" yaml:"targets"`
+	Labels  labels   `json:"labels" yaml:"labels"`
 }
 
-type prometheusConfiguration struct {
-	Targets []string `json:"targets"`
-	Labels  labels   `json:"labels"`
+// ChangedWrites the number of runs which wrote a changed configuration
+var ChangedWrites = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_changed_writes_total",
+		Help: "Number of service discovery runs which wrote a changed configuration",
+	},
+)
+
+// UnchangedRuns the number of runs which skipped the write as the configuration had not changed
+var UnchangedRuns = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "service_discovery_unchanged_runs_total",
+		Help: "Number of service discovery runs which skipped the write as the configuration was unchanged",
+	},
+)
+
+// lastWrittenReader is implemented by writers which can return what they last wrote
+type lastWrittenReader interface {
+	LastWritten() ([]byte, error)
 }
 
 type GraphQLResponse struct {
@@ -36,6 +58,8 @@ type Healthcheck struct {
 	URL     string   `json:"url"`
 	IsLive  bool     `json:"isLive"`
 	Systems []System `json:"monitors"`
+	// Fields every field biz-ops returned for the healthcheck
+	Fields map[string]interface{} `json:"-"`
 }
 
 type System struct {
@@ -45,33 +69,239 @@ type System struct {
 type BizOps struct {
 	Writer    io.Writer
 	ApiClient graphQlClient
+	// AllowPartialData writes the healthchecks biz-ops did return when the
+	// response also contained GraphQL errors, rather than failing the run.
+	AllowPartialData bool
+	// PageSize the number of healthchecks fetched per request, 0 fetches them all in one request
+	PageSize int
+	// PageConcurrency the maximum number of pages fetched at once
+	PageConcurrency int
+	// LabelMappings the healthcheck fields written as labels, DefaultLabelMappings if empty
+	LabelMappings []LabelMapping
+	// Filters the rules applied in order to decide which targets are written
+	Filters []FilterRule
+	// Guard stops the configuration being replaced with one with drastically fewer targets
+	Guard BlastRadiusGuard
+	// Targets is given the configuration after every successful run, if set
+	Targets *TargetStore
+	// Sources discover targets in addition to biz-ops. A target found by more than one
+	// source is written with the labels from biz-ops, or else the first source listed.
+	Sources []Source
+	// Overrides manually excludes, adds or relabels targets after they are discovered, if set
+	Overrides *OverridesFile
+	// Snapshot persists the healthchecks from each successful fetch, if set
+	Snapshot *SnapshotFile
+	// Shards splits the targets across this many files by a stable hash of their URL, if more than 1.
+	// The Writer must be able to write other files, as the file writer can.
+	Shards int
+	// Partition writes a file for each value of a label instead of a single file, if set.
+	// The Writer must be able to write, find and remove other files, as the file writer can.
+	Partition *Partitioning
+	// Encoder serialises the written configuration, JSON if not set. The filenames take its extension.
+	Encoder Encoder
+	// Exporter rewrites the targets to be scraped through the exporter, in the written and served configuration, if set
+	Exporter *ProbeExporter
+	// DryRun builds the configuration and compares it with what was last written without writing,
+	// removing or storing anything. A blast radius guard block is returned in the Result rather than failing the run.
+	DryRun bool
+
+	// fetched the snapshot of the healthchecks fetched in the current run, saved once the run succeeds
+	fetched *Snapshot
 }
 
-func (bizOps *BizOps) Write() error {
-	var responsePayload GraphQLResponse
-	err := bizOps.ApiClient.Query(`{
-	  Healthchecks {
-	    code,
-	    url,
-	    isLive,
-	    monitors {
-	      code
-	    }
-	  }
-	}
-	`, &responsePayload)
+// filters returns the filter rules ready to be applied
+func (bizOps *BizOps) filters() ([]FilterRule, error) {
+	filters := make([]FilterRule, len(bizOps.Filters))
+	for i, rule := range bizOps.Filters {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		filters[i] = rule
+	}
+	return filters, nil
+}
+
+func (bizOps *BizOps) labelMappings() []LabelMapping {
+	if len(bizOps.LabelMappings) == 0 {
+		return DefaultLabelMappings
+	}
+	return bizOps.LabelMappings
+}
+
+// lastWritten returns the configuration the writer last wrote, or nil if it can't say
+func lastWritten(writer io.Writer) []byte {
+	reader, ok := writer.(lastWrittenReader)
+	if !ok {
+		return nil
+	}
+	lastWritten, err := reader.LastWritten()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event": "ERROR_READING_CONFIGURATION",
+			"err":   err,
+		}).Warn("Could not read the existing configuration, it will be overwritten.")
+		return nil
+	}
+	return lastWritten
+}
+
+func (bizOps *BizOps) storeTargets(serviceDiscoveryJSON []byte, fetchedAt time.Time) {
+	if bizOps.Targets != nil {
+		bizOps.Targets.Set(serviceDiscoveryJSON, fetchedAt)
+	}
+}
+
+// saveSnapshot persists the healthchecks fetched in the run, if there is a snapshot file, so they can be used
+// after a restart. It is only called once the run has passed the blast radius check and been written, so a
+// truncated response which was blocked never becomes the snapshot. Unchanged healthchecks are only saved
+// once the snapshot is SnapshotMaxAge old, so the file isn't rewritten every run.
+func (bizOps *BizOps) saveSnapshot() {
+	if bizOps.Snapshot == nil || bizOps.fetched == nil {
+		return
+	}
+	if existing, err := bizOps.Snapshot.Load(); err == nil && existing != nil &&
+		bizOps.fetched.FetchedAt.Sub(existing.FetchedAt) < SnapshotMaxAge && existing.sameHealthchecks(*bizOps.fetched) {
+		return
+	}
+	if err := bizOps.Snapshot.Save(*bizOps.fetched); err != nil {
+		log.WithFields(log.Fields{
+			"event": "ERROR_SAVING_SNAPSHOT",
+			"file":  bizOps.Snapshot.Path,
+			"err":   err,
+		}).Warn("Could not save the snapshot of the healthchecks.")
+	}
+}
+
+// WarmStart stores the targets from the saved snapshot, so they can be served before biz-ops has
+// been reached. The configuration file is left alone. It returns false if there is no usable snapshot.
+func (bizOps *BizOps) WarmStart(ctx context.Context) bool {
+	if bizOps.Snapshot == nil || bizOps.Targets == nil {
+		return false
+	}
+	snapshot, err := bizOps.Snapshot.Load()
+	if err != nil || snapshot == nil {
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "ERROR_LOADING_SNAPSHOT",
+				"file":  bizOps.Snapshot.Path,
+				"err":   err,
+			}).Warn("Could not load the snapshot of the healthchecks.")
+		}
+		return false
+	}
 
+	sources := append([]Source{snapshotSource{bizOps: bizOps, snapshot: *snapshot}}, bizOps.Sources...)
+	configuration, targetCount, err := bizOps.build(ctx, sources)
 	if err != nil {
-		return err
+		log.WithFields(log.Fields{
+			"event": "ERROR_WARM_START",
+			"err":   err,
+		}).Warn("Could not build the targets from the snapshot.")
+		return false
+	}
+	serviceDiscoveryJSON, err := bizOps.withExporter(JSONEncoder{}).Encode(configuration)
+	if err != nil {
+		return false
+	}
+
+	bizOps.storeTargets(serviceDiscoveryJSON, snapshot.FetchedAt)
+	log.WithFields(log.Fields{
+		"event":       "WARM_START",
+		"targetCount": targetCount,
+		"fetchedAt":   snapshot.FetchedAt.Format(time.RFC3339),
+	}).Info("Serving the targets from the snapshot until biz-ops is reached.")
+	return true
+}
+
+// parseConfiguration returns the configuration which was last written, or nil if there isn't a usable one
+func parseConfiguration(lastWritten []byte, encoder Encoder) []prometheusConfiguration {
+	if lastWritten == nil {
+		return nil
 	}
+	var previous []prometheusConfiguration
+	if err := encoder.Decode(lastWritten, &previous); err != nil {
+		log.WithFields(log.Fields{
+			"event": "ERROR_PARSING_CONFIGURATION",
+			"err":   err,
+		}).Warn("Could not parse the existing configuration, it will be overwritten.")
+		return nil
+	}
+	return previous
+}
+
+// sortConfiguration orders the groups by their labels and the targets within them so the output is deterministic
+func sortConfiguration(configuration []prometheusConfiguration) {
+	for i, group := range configuration {
+		sort.Strings(group.Targets)
+		targets := group.Targets[:0]
+		for j, target := range group.Targets {
+			if j == 0 || target != group.Targets[j-1] {
+				targets = append(targets, target)
+			}
+		}
+		configuration[i].Targets = targets
+	}
+	sort.Slice(configuration, func(i, j int) bool {
+		return configuration[i].Labels.key() < configuration[j].Labels.key()
+	})
+}
+
+// Write fetches the healthchecks from biz-ops and writes the service discovery configuration
+func (bizOps *BizOps) Write() error {
+	return bizOps.WriteContext(context.Background())
+}
 
-	healthchecks := responsePayload.Data.Healthchecks
+// WriteContext behaves like Write, abandoning the run if the context is cancelled
+// before the configuration has started to be written.
+func (bizOps *BizOps) WriteContext(ctx context.Context) error {
+	_, err := bizOps.Run(ctx)
+	return err
+}
+
+// Result the outcome of a successful service discovery run
+type Result struct {
+	// Changed whether a changed configuration was written
+	Changed bool       `json:"changed"`
+	Diff    TargetDiff `json:"diff"`
+	// Configuration the whole configuration encoded in the configured format
+	Configuration []byte `json:"-"`
+	// Blocked the blast radius guard's refusal to write the configuration, only set for a DryRun
+	Blocked error `json:"-"`
+}
 
-	configuration := make([]prometheusConfiguration, 0)
+// Name identifies biz-ops as a Source
+func (bizOps *BizOps) Name() string {
+	return "biz-ops"
+}
 
-	var labelsToUrls = map[labels][]string{}
-	// maintain an orderd slice of keys so iteration order is stable
-	var labelsKeys = make([]labels, 0)
+// Discover fetches the healthchecks from biz-ops, returning a target for each
+// label set of each healthcheck which passes the filters
+func (bizOps *BizOps) Discover(ctx context.Context) ([]Target, error) {
+	fetchedAt := time.Now()
+	healthchecks, err := bizOps.fetchHealthchecks(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &FetchError{Err: err}
+	}
+
+	targets, err := bizOps.healthcheckTargets(healthchecks, true)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newSnapshot(healthchecks, fetchedAt)
+	bizOps.fetched = &snapshot
+	return targets, nil
+}
+
+// healthcheckTargets returns a target for each label set of each healthcheck which passes the filters,
+// counting the invalid and filtered targets if recordMetrics is set
+func (bizOps *BizOps) healthcheckTargets(healthchecks []Healthcheck, recordMetrics bool) ([]Target, error) {
+	filters, err := bizOps.filters()
+	if err != nil {
+		return nil, err
+	}
 
 	if len(healthchecks) == 0 {
 		err = errors.New("returned healthchecks were empty")
@@ -80,8 +310,13 @@ func (bizOps *BizOps) Write() error {
 			"err":          err,
 			"healthchecks": healthchecks,
 		}).Error(err)
-		return err
+		return nil, err
 	}
+
+	targets := make([]Target, 0, len(healthchecks))
+	mappings := bizOps.labelMappings()
+	dropped := make([]int, len(filters))
+	invalidURLs := 0
 	for _, healthcheck := range healthchecks {
 		// check the URL is parseable, ignore it on parse errors.
 		_, err := url.ParseRequestURI(healthcheck.URL)
@@ -91,78 +326,268 @@ func (bizOps *BizOps) Write() error {
 				"url":   healthcheck.URL,
 				"err":   err,
 			}).Error("Failed to parse a health check URL from the Biz Ops API.")
+			invalidURLs++
 			continue
 		}
 
-		if len(healthcheck.Systems) == 0 {
-			healthcheck.Systems = append(healthcheck.Systems, System{SystemCode: ""})
-		}
-		for _, system := range healthcheck.Systems {
-			observe := "no"
-			if healthcheck.IsLive {
-				observe = "yes"
-			}
-			checkLabels := labels{
-				System:  system.SystemCode,
-				Observe: observe,
+		fields := healthcheck.fields()
+		for _, checkLabels := range labelSets(mappings, fields) {
+			if index := droppedBy(filters, healthcheck, fields, checkLabels); index >= 0 {
+				dropped[index]++
+				log.WithFields(log.Fields{
+					"event":  "TARGET_FILTERED",
+					"url":    healthcheck.URL,
+					"labels": checkLabels,
+					"rule":   filters[index].String(),
+					"reason": filters[index].reason(),
+				}).Debug("A target was excluded by a filter rule.")
+				continue
 			}
+			targets = append(targets, Target{URL: healthcheck.URL, Labels: checkLabels})
+		}
+	}
 
-			if len(labelsToUrls[checkLabels]) == 0 {
-				labelsToUrls[checkLabels] = make([]string, 0)
-				labelsKeys = append(labelsKeys, checkLabels)
-			}
-			labelsToUrls[checkLabels] = append(labelsToUrls[checkLabels], healthcheck.URL)
+	if recordMetrics {
+		InvalidURLs.Set(float64(invalidURLs))
+		for index, rule := range filters {
+			FilteredTargets.WithLabelValues(rule.String()).Add(float64(dropped[index]))
 		}
 	}
+	return targets, nil
+}
+
+// build discovers the targets from the sources and applies the overrides, returning the sorted
+// configuration and the number of targets in it
+func (bizOps *BizOps) build(ctx context.Context, sources []Source) ([]prometheusConfiguration, int, error) {
+	fetchStart := time.Now()
+	targets, err := bizOps.discoverAll(ctx, sources)
+	observePhase("fetch", fetchStart)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	hasChecks := false
-	for _, l := range labelsKeys {
-		urls := labelsToUrls[l]
-		if len(urls) > 0 {
-			hasChecks = true
+	transformStart := time.Now()
+	if bizOps.Overrides != nil {
+		overrides, err := bizOps.Overrides.Load()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"event": "ERROR_LOADING_OVERRIDES",
+				"err":   err,
+			}).Error("The overrides could not be loaded.")
+			return nil, 0, err
 		}
-		configuration = append(configuration, prometheusConfiguration{
-			Labels:  l,
-			Targets: urls,
-		})
+		targets = overrides.apply(targets, time.Now())
 	}
 
-	if !hasChecks {
+	configuration := groupTargets(targets)
+	if len(configuration) == 0 {
 		err = errors.New("processed healthchecks were empty")
 		log.WithFields(log.Fields{
-			"event":        "CONFIGURATION_EMPTY_PARSED_HEALTHCHECKS",
-			"err":          err,
-			"healthchecks": healthchecks,
+			"event": "CONFIGURATION_EMPTY_PARSED_HEALTHCHECKS",
+			"err":   err,
 		}).Error(err)
-		return err
+		return nil, 0, err
+	}
+
+	sortConfiguration(configuration)
+	observePhase("transform", transformStart)
+	return configuration, len(targets), nil
+}
+
+// output a file the configuration, or part of it, is written to
+type output struct {
+	// name identifies the output in logs, empty when the whole configuration is written to one file
+	name string
+	// filename the file in the output directory, empty if the writer can't write other files
+	filename      string
+	writer        io.Writer
+	configuration []prometheusConfiguration
+	lastWritten   []byte
+	previous      []prometheusConfiguration
+}
+
+// load reads the configuration the output last wrote
+func (out *output) load(encoder Encoder) {
+	out.lastWritten = lastWritten(out.writer)
+	out.previous = parseConfiguration(out.lastWritten, encoder)
+}
+
+// outputs returns the files the configuration is written to
+func (bizOps *BizOps) outputs(configuration []prometheusConfiguration) ([]output, error) {
+	if bizOps.Shards <= 1 {
+		ShardTargets.Reset()
+	}
+	if bizOps.Partition == nil {
+		PartitionTargets.Reset()
+	}
+	if bizOps.Partition != nil {
+		return bizOps.partitionOutputs(configuration)
+	}
+	if bizOps.Shards > 1 {
+		return bizOps.shardOutputs(configuration)
 	}
+	renamer, ok := bizOps.Writer.(filenameWriter)
+	if !ok {
+		return []output{{writer: bizOps.Writer, configuration: configuration}}, nil
+	}
+	filename := withExtension(Filename, bizOps.encoder())
+	return []output{{filename: filename, writer: renamer.WithFilename(filename), configuration: configuration}}, nil
+}
 
-	serviceDiscoveryJSON, err := json.MarshalIndent(configuration, "", "  ")
+// mergedPrevious the configuration last written across every output, nil if none of them had been written
+func mergedPrevious(outputs ...[]output) []prometheusConfiguration {
+	var previous []prometheusConfiguration
+	for _, list := range outputs {
+		for _, out := range list {
+			if out.previous != nil {
+				previous = append(previous, out.previous...)
+			}
+		}
+	}
+	return previous
+}
 
+// Run behaves like WriteContext, also returning how the configuration changed
+func (bizOps *BizOps) Run(ctx context.Context) (Result, error) {
+	fetchedAt := time.Now()
+	bizOps.fetched = nil
+	configuration, targetCount, err := bizOps.build(ctx, bizOps.sources())
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 
-	written, err := bizOps.Writer.Write(serviceDiscoveryJSON)
+	defer observePhase("write", time.Now())
+	outputs, err := bizOps.outputs(configuration)
 	if err != nil {
+		return Result{}, err
+	}
+	for i := range outputs {
+		outputs[i].load(bizOps.encoder())
+	}
+	manifest, stale := bizOps.staleOutputs(outputs)
+
+	// the whole configuration is checked before anything is written, so targets which
+	// only move between files aren't counted as removed
+	previous := mergedPrevious(outputs, stale)
+	blocked := bizOps.checkBlastRadius(previous, configuration)
+	if blocked == nil && bizOps.Partition != nil {
+		blocked = bizOps.checkPartitions(outputs, stale)
+	}
+	if bizOps.DryRun {
+		encoded, err := bizOps.encoder().Encode(configuration)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Diff: diffConfigurations(previous, configuration), Configuration: encoded, Blocked: blocked}, nil
+	}
+	if blocked != nil {
+		return Result{}, blocked
+	}
+
+	// once the first output has started to be written every output is, so a target moving
+	// between files is never left out of both by a shutdown part way through
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	changed := false
+	for _, out := range outputs {
+		written, err := writeOutput(out, bizOps.encoder())
+		if err != nil {
+			return Result{}, err
+		}
+		changed = changed || written
+	}
+
+	// stale files are only removed once the others are written, so a failed run never loses targets
+	for _, out := range stale {
+		if err := bizOps.removeOutput(out); err != nil {
+			return Result{}, err
+		}
+		changed = true
+	}
+	if err := bizOps.writeManifest(manifest, outputs); err != nil {
+		return Result{}, err
+	}
+
+	serviceDiscoveryJSON, err := bizOps.withExporter(JSONEncoder{}).Encode(configuration)
+	if err != nil {
+		return Result{}, err
+	}
+	encoded, err := bizOps.encoder().Encode(configuration)
+	if err != nil {
+		return Result{}, err
+	}
+	bizOps.storeTargets(serviceDiscoveryJSON, fetchedAt)
+	recordGroups(configuration)
+	bizOps.saveSnapshot()
+
+	diff := diffConfigurations(previous, configuration)
+	if !changed {
+		UnchangedRuns.Inc()
 		log.WithFields(log.Fields{
-			"event": "CONFIGURATION_UPDATE_FAILED",
-			"err":   err,
+			"event":   "CONFIGURATION_UNCHANGED",
+			"outputs": len(outputs),
+		}).Debug("Health check targets are unchanged, skipping the write.")
+		return Result{Diff: diff, Configuration: encoded}, nil
+	}
+
+	ChangedWrites.Inc()
+	fields := log.Fields{
+		"event":       "CONFIGURATION_UPDATED",
+		"targetCount": targetCount,
+		"outputs":     len(outputs),
+	}
+	if previous != nil {
+		diff.record()
+		fields["added"] = len(diff.Added)
+		fields["removed"] = len(diff.Removed)
+		fields["relabelled"] = len(diff.Relabelled)
+	}
+	log.WithFields(fields).Info("Health check targets have been updated.")
+	return Result{Changed: true, Diff: diff, Configuration: encoded}, nil
+}
+
+// writeOutput writes the output's configuration unless it is unchanged, returning whether it was written
+func writeOutput(out output, encoder Encoder) (bool, error) {
+	encoded, err := encoder.Encode(out.configuration)
+
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha256.Sum256(encoded)
+	fields := log.Fields{
+		"hash": hex.EncodeToString(hash[:]),
+	}
+	if out.name != "" {
+		fields["output"] = out.name
+	}
+	if out.lastWritten != nil && hash == sha256.Sum256(out.lastWritten) {
+		fields["event"] = "OUTPUT_UNCHANGED"
+		log.WithFields(fields).Debug("The output is unchanged, skipping the write.")
+		return false, nil
+	}
+
+	written, err := out.writer.Write(encoded)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event":  "CONFIGURATION_UPDATE_FAILED",
+			"output": out.name,
+			"err":    err,
 		}).Error("Health check targets failed to update.")
-		return err
+		return false, err
 	} else if written == 0 {
 		err := errors.New("0 bytes written when updating health check targets")
 		log.WithFields(log.Fields{
-			"event": "CONFIGURATION_UPDATE_EMPTY",
-			"err":   err,
+			"event":  "CONFIGURATION_UPDATE_EMPTY",
+			"output": out.name,
+			"err":    err,
 		}).Error("Health check targets update wrote 0 bytes.")
-		return err
+		return false, err
 	}
 
-	log.WithFields(log.Fields{
-		"event":       "CONFIGURATION_UPDATED",
-		"targetCount": len(healthchecks),
-	}).Info("Health check targets have been updated.")
-
-	return nil
+	fields["event"] = "OUTPUT_WRITTEN"
+	fields["targetCount"] = countTargets(out.configuration)
+	log.WithFields(fields).Debug("The output has been written.")
+	return true, nil
 }
@@ -1,10 +1,13 @@
 package servicediscovery
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/Financial-Times/prometheus-biz-ops-service-discovery/internal/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -24,7 +27,11 @@ type MockAPIClient struct {
 	response GraphQLResponse
 }
 
-func (c MockAPIClient) Query(query string, response interface{}) error {
+func (c MockAPIClient) Execute(ctx context.Context, request api.Request, response interface{}) error {
+	if graphQLErr, ok := c.err.(*api.GraphQLError); ok && graphQLErr.PartialData {
+		*response.(*GraphQLResponse) = c.response
+		return c.err
+	}
 	if c.err != nil {
 		return c.err
 	}
@@ -41,14 +48,25 @@ func newGraphQLResponse(checks []Healthcheck) GraphQLResponse {
 }
 
 func TestWrite(t *testing.T) {
+	partialDataError := &api.GraphQLError{
+		Errors:      []api.GraphQLErrorDetail{{Message: "Cannot return null for non-nullable field"}},
+		PartialData: true,
+	}
+	totalFailureError := &api.GraphQLError{
+		Errors: []api.GraphQLErrorDetail{{Message: "Syntax Error"}},
+	}
+
 	testCases := map[string]struct {
-		bizOpsResponse GraphQLResponse
-		expectedWrite  string
-		bizOpsError    error
-		expectedErr    error
-		writerErr      error
+		bizOpsResponse   GraphQLResponse
+		expectedWrite    string
+		bizOpsError      error
+		expectedErr      error
+		fetchErr         bool
+		writerErr        error
+		allowPartialData bool
+		labelMappings    []LabelMapping
 	}{
-		"successful biz-ops response should write to JSON": {
+		"successful biz-ops response should write to JSON sorted by labels and target": {
 			bizOpsResponse: newGraphQLResponse([]Healthcheck{
 				Healthcheck{
 					ID:     "someSystemCode.check",
@@ -91,16 +109,6 @@ func TestWrite(t *testing.T) {
 					},
 				}}),
 			expectedWrite: `[
-				{
-				    "targets": [
-						"https://url.com",
-						"https://url-app.com"
-					],
-					"labels": {
-						"observe": "yes",
-						"system": "someSystemCode"
-					}
-				},
 				{
 				    "targets": [
 						"https://url.test.com"
@@ -118,6 +126,16 @@ func TestWrite(t *testing.T) {
 						"observe": "no",
 						"system": "someSystemCode2"
 					}
+				},
+				{
+				    "targets": [
+						"https://url-app.com",
+						"https://url.com"
+					],
+					"labels": {
+						"observe": "yes",
+						"system": "someSystemCode"
+					}
 				}
 			]`,
 			bizOpsError: nil,
@@ -189,6 +207,56 @@ func TestWrite(t *testing.T) {
 			bizOpsError: nil,
 			expectedErr: nil,
 		},
+		"configured label mappings should group targets on every mapped label": {
+			bizOpsResponse: newGraphQLResponse([]Healthcheck{
+				Healthcheck{
+					URL: "https://url.com",
+					Fields: map[string]interface{}{
+						"code":        "someSystemCode.check",
+						"serviceTier": "Platinum",
+						"monitors": []interface{}{
+							map[string]interface{}{"deliveredBy": map[string]interface{}{"code": "team-a"}},
+						},
+					},
+				},
+				Healthcheck{
+					URL: "https://url2.com",
+					Fields: map[string]interface{}{
+						"code":        "someSystemCode2.check",
+						"serviceTier": "Bronze",
+						"monitors": []interface{}{
+							map[string]interface{}{"deliveredBy": map[string]interface{}{"code": "team-a"}},
+						},
+					},
+				}}),
+			expectedWrite: `[
+				{
+					"targets": [
+						"https://url.com"
+					],
+					"labels": {
+						"team": "team-a",
+						"tier": "platinum",
+						"check": "someSystemCode.check"
+					}
+				},
+				{
+					"targets": [
+						"https://url2.com"
+					],
+					"labels": {
+						"team": "team-a",
+						"tier": "bronze",
+						"check": "someSystemCode2.check"
+					}
+				}
+			]`,
+			labelMappings: []LabelMapping{
+				{Label: "team", Field: "monitors.deliveredBy.code"},
+				{Label: "tier", Field: "serviceTier", Transform: "lower"},
+				{Label: "check", Field: "code"},
+			},
+		},
 		"invalid healthcheck URL in biz-ops response should be skipped": {
 			bizOpsResponse: newGraphQLResponse([]Healthcheck{
 				Healthcheck{
@@ -252,6 +320,50 @@ func TestWrite(t *testing.T) {
 			expectedWrite:  "",
 			bizOpsError:    errors.New("biz-ops API call failed"),
 			expectedErr:    errors.New("biz-ops API call failed"),
+			fetchErr:       true,
+		},
+		"graphql errors with partial data should return an error by default": {
+			bizOpsResponse: newGraphQLResponse([]Healthcheck{
+				Healthcheck{
+					ID:     "someSystemCode.check",
+					URL:    "https://url.com",
+					IsLive: true,
+				},
+			}),
+			expectedWrite: "",
+			bizOpsError:   partialDataError,
+			expectedErr:   partialDataError,
+			fetchErr:      true,
+		},
+		"graphql errors with partial data should write the partial data when allowed": {
+			bizOpsResponse: newGraphQLResponse([]Healthcheck{
+				Healthcheck{
+					ID:     "someSystemCode.check",
+					URL:    "https://url.com",
+					IsLive: true,
+				},
+			}),
+			expectedWrite: `[
+				{
+					"targets": [
+						"https://url.com"
+					],
+					"labels": {
+						"observe": "yes"
+					}
+				}
+			]`,
+			bizOpsError:      partialDataError,
+			expectedErr:      nil,
+			allowPartialData: true,
+		},
+		"graphql errors without data should return an error even when partial data is allowed": {
+			bizOpsResponse:   GraphQLResponse{},
+			expectedWrite:    "",
+			bizOpsError:      totalFailureError,
+			expectedErr:      totalFailureError,
+			fetchErr:         true,
+			allowPartialData: true,
 		},
 		"with writer error should return an error": {
 			bizOpsResponse: newGraphQLResponse([]Healthcheck{
@@ -285,12 +397,18 @@ func TestWrite(t *testing.T) {
 			}
 
 			serviceDiscovery := BizOps{
-				Writer:    &writer,
-				ApiClient: &apiClient,
+				Writer:           &writer,
+				ApiClient:        &apiClient,
+				AllowPartialData: test.allowPartialData,
+				LabelMappings:    test.labelMappings,
 			}
 
 			err := serviceDiscovery.Write()
 
+			if test.fetchErr {
+				require.IsType(t, &FetchError{}, err, "Expected a fetch error")
+				err = err.(*FetchError).Cause()
+			}
 			if test.expectedErr != nil {
 				assert.Equalf(t, test.expectedErr, err, "Expected error %s", test.expectedErr)
 			} else {
@@ -308,3 +426,77 @@ func TestWrite(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteContextCancelled(t *testing.T) {
+	writer := MockWriter{}
+	apiClient := MockAPIClient{
+		response: newGraphQLResponse([]Healthcheck{
+			Healthcheck{
+				ID:     "someSystemCode.check",
+				URL:    "https://url.com",
+				IsLive: true,
+			},
+		}),
+	}
+	serviceDiscovery := BizOps{
+		Writer:    &writer,
+		ApiClient: &apiClient,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := serviceDiscovery.WriteContext(ctx)
+
+	assert.Equal(t, context.Canceled, err, "Expected the context error to be returned")
+	writer.AssertNotCalled(t, "Write")
+}
+
+type RecordingWriter struct {
+	content []byte
+	writes  int
+}
+
+func (w *RecordingWriter) Write(p []byte) (n int, err error) {
+	w.content = append([]byte(nil), p...)
+	w.writes++
+	return len(p), nil
+}
+
+func (w *RecordingWriter) LastWritten() ([]byte, error) {
+	return w.content, nil
+}
+
+func TestWriteSkipsUnchangedConfiguration(t *testing.T) {
+	writer := RecordingWriter{}
+	apiClient := MockAPIClient{
+		response: newGraphQLResponse([]Healthcheck{
+			Healthcheck{URL: "https://url2.com", IsLive: true},
+			Healthcheck{URL: "https://url.com", IsLive: true},
+		}),
+	}
+	serviceDiscovery := BizOps{
+		Writer:    &writer,
+		ApiClient: &apiClient,
+		Targets:   NewTargetStore(),
+	}
+
+	changedBefore := testutil.ToFloat64(ChangedWrites)
+	unchangedBefore := testutil.ToFloat64(UnchangedRuns)
+
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+
+	// the same healthchecks in a different order should produce the same configuration
+	apiClient.response = newGraphQLResponse([]Healthcheck{
+		Healthcheck{URL: "https://url.com", IsLive: true},
+		Healthcheck{URL: "https://url2.com", IsLive: true},
+	})
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+
+	assert.Equal(t, 1, writer.writes, "Expected the unchanged configuration not to be written again")
+	assert.Equal(t, changedBefore+1, testutil.ToFloat64(ChangedWrites), "Expected one changed write")
+	assert.Equal(t, unchangedBefore+1, testutil.ToFloat64(UnchangedRuns), "Expected one unchanged run")
+
+	stored, _, ok := serviceDiscovery.Targets.Targets()
+	assert.True(t, ok, "Expected the targets to be stored")
+	assert.Equal(t, writer.content, stored, "Expected the written targets to be stored")
+}
@@ -0,0 +1,90 @@
+package servicediscovery
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ShardTargets the number of distinct targets written to each shard in the last run
+var ShardTargets = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_shard_targets",
+		Help: "Number of targets written to each shard of the service discovery configuration",
+	},
+	[]string{"shard"},
+)
+
+// filenameWriter is implemented by writers which can write another file alongside their own
+type filenameWriter interface {
+	WithFilename(filename string) io.Writer
+}
+
+// ShardFilename the name of the file the shard with the given index is written to
+func ShardFilename(shard int) string {
+	return fmt.Sprintf("%s-shard-%d.json", strings.TrimSuffix(Filename, ".json"), shard)
+}
+
+// shardFor picks the shard for a target by rendezvous hashing, so adding a shard
+// only moves the targets which the new shard wins from the others
+func shardFor(target string, shards int) int {
+	best := 0
+	var bestWeight uint64
+	for shard := 0; shard < shards; shard++ {
+		hash := sha256.Sum256([]byte(target + "\x00" + strconv.Itoa(shard)))
+		if weight := binary.BigEndian.Uint64(hash[:8]); shard == 0 || weight > bestWeight {
+			best, bestWeight = shard, weight
+		}
+	}
+	return best
+}
+
+// shardConfiguration splits the configuration into the given number of shards, keeping
+// every label set of a target in the same shard and the groups in the same order
+func shardConfiguration(configuration []prometheusConfiguration, shards int) [][]prometheusConfiguration {
+	sharded := make([][]prometheusConfiguration, shards)
+	for i := range sharded {
+		sharded[i] = make([]prometheusConfiguration, 0)
+	}
+	for _, group := range configuration {
+		targets := make([][]string, shards)
+		for _, target := range group.Targets {
+			shard := shardFor(target, shards)
+			targets[shard] = append(targets[shard], target)
+		}
+		for shard, shardTargets := range targets {
+			if len(shardTargets) > 0 {
+				sharded[shard] = append(sharded[shard], prometheusConfiguration{Targets: shardTargets, Labels: group.Labels})
+			}
+		}
+	}
+	return sharded
+}
+
+// shardOutputs returns an output for each shard of the configuration
+func (bizOps *BizOps) shardOutputs(configuration []prometheusConfiguration) ([]output, error) {
+	writer, ok := bizOps.Writer.(filenameWriter)
+	if !ok {
+		return nil, fmt.Errorf("the configuration writer does not support writing %d shards", bizOps.Shards)
+	}
+
+	// reset so the series of shards which have been removed aren't left behind
+	ShardTargets.Reset()
+	outputs := make([]output, 0, bizOps.Shards)
+	for shard, shardConfiguration := range shardConfiguration(configuration, bizOps.Shards) {
+		ShardTargets.WithLabelValues(strconv.Itoa(shard)).Set(float64(countTargets(shardConfiguration)))
+		filename := withExtension(ShardFilename(shard), bizOps.encoder())
+		outputs = append(outputs, output{
+			name:          fmt.Sprintf("shard-%d", shard),
+			filename:      filename,
+			writer:        writer.WithFilename(filename),
+			configuration: shardConfiguration,
+		})
+	}
+	return outputs, nil
+}
@@ -0,0 +1,174 @@
+package servicediscovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardForMovesFewTargetsWhenAShardIsAdded(t *testing.T) {
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		target := fmt.Sprintf("https://service-%d.ft.com/__health", i)
+		before := shardFor(target, 4)
+		after := shardFor(target, 5)
+
+		assert.Equal(t, before, shardFor(target, 4), "Expected the same shard every time")
+		if before != after {
+			moved++
+			assert.Equal(t, 4, after, "Expected targets to only move to the new shard")
+		}
+	}
+	assert.InDelta(t, 200, moved, 60, "Expected about a fifth of the targets to move to the new shard")
+}
+
+func TestRunWritesShards(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	checks := make([]Healthcheck, 0)
+	for i := 0; i < 20; i++ {
+		checks = append(checks, Healthcheck{URL: fmt.Sprintf("https://service-%d.ft.com/__health", i), IsLive: i%2 == 0})
+	}
+	serviceDiscovery := BizOps{
+		Writer:    NewFileWriter("/etc/prometheus", memoryFS),
+		ApiClient: &MockAPIClient{response: newGraphQLResponse(checks)},
+		Shards:    3,
+	}
+
+	result, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+	assert.True(t, result.Changed, "Expected the shards to be written")
+
+	seen := map[string]int{}
+	for shard := 0; shard < 3; shard++ {
+		content, err := afero.ReadFile(memoryFS, filepath.Join("/etc/prometheus", ShardFilename(shard)))
+		require.NoError(t, err, "Expected the shard file to be written")
+		assert.Empty(t, ValidateConfiguration(content), "Expected each shard to be a valid file_sd file")
+
+		var configuration []prometheusConfiguration
+		require.NoError(t, json.Unmarshal(content, &configuration))
+		count := 0
+		for _, group := range configuration {
+			for _, target := range group.Targets {
+				assert.Equal(t, shard, shardFor(target, 3), "Expected the target in its own shard")
+				seen[target]++
+				count++
+			}
+		}
+		assert.Equal(t, float64(count), testutil.ToFloat64(ShardTargets.WithLabelValues(fmt.Sprint(shard))), "Expected the shard's target count")
+	}
+	assert.Len(t, seen, len(checks), "Expected every target in a shard")
+	for target, count := range seen {
+		assert.Equal(t, 1, count, "Expected %s in exactly one shard", target)
+	}
+
+	unchanged := testutil.ToFloat64(UnchangedRuns)
+	result, err = serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+	assert.False(t, result.Changed, "Expected the unchanged shards to be skipped")
+	assert.Equal(t, unchanged+1, testutil.ToFloat64(UnchangedRuns), "Expected the run to be counted once")
+}
+
+func TestRunRemovesStaleShards(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	checks := make([]Healthcheck, 0)
+	for i := 0; i < 20; i++ {
+		checks = append(checks, Healthcheck{URL: fmt.Sprintf("https://service-%d.ft.com/__health", i), IsLive: true})
+	}
+	serviceDiscovery := BizOps{
+		Writer:    NewFileWriter("/etc/prometheus", memoryFS),
+		ApiClient: &MockAPIClient{response: newGraphQLResponse(checks)},
+	}
+	require.NoError(t, afero.WriteFile(memoryFS, "/etc/prometheus/other.json", []byte("[]"), 0644))
+
+	_, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+
+	serviceDiscovery.Shards = 3
+	added := testutil.ToFloat64(TargetsAdded)
+	removed := testutil.ToFloat64(TargetsRemoved)
+	changed := testutil.ToFloat64(ChangedWrites)
+	result, err := serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+	assert.True(t, result.Changed, "Expected the shards to be written")
+	assert.Empty(t, result.Diff.Added, "Expected no targets to be added when they only move between files")
+	assert.Empty(t, result.Diff.Removed, "Expected no targets to be removed when they only move between files")
+	assert.Equal(t, added, testutil.ToFloat64(TargetsAdded), "Expected no targets to be counted as added")
+	assert.Equal(t, removed, testutil.ToFloat64(TargetsRemoved), "Expected no targets to be counted as removed")
+	assert.Equal(t, changed+1, testutil.ToFloat64(ChangedWrites), "Expected the run to be counted once")
+	exists, _ := afero.Exists(memoryFS, filepath.Join("/etc/prometheus", Filename))
+	assert.False(t, exists, "Expected the single file to be removed once sharding is enabled")
+
+	serviceDiscovery.Shards = 2
+	_, err = serviceDiscovery.Run(context.Background())
+	require.NoError(t, err, "Error not expected")
+	for shard := 0; shard < 3; shard++ {
+		exists, _ := afero.Exists(memoryFS, filepath.Join("/etc/prometheus", ShardFilename(shard)))
+		assert.Equalf(t, shard < 2, exists, "Expected only the files of the current shards, checking shard %d", shard)
+	}
+	assert.Equal(t, 2, testutil.CollectAndCount(ShardTargets), "Expected the series of the removed shard to be deleted")
+	exists, _ = afero.Exists(memoryFS, "/etc/prometheus/other.json")
+	assert.True(t, exists, "Expected files which weren't written by the service to be left alone")
+}
+
+func TestRunFailsWhenTheWriterCannotShard(t *testing.T) {
+	writer := RecordingWriter{}
+	serviceDiscovery := BizOps{
+		Writer: &writer,
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				Healthcheck{URL: "https://url.com", IsLive: true},
+			}),
+		},
+		Shards: 2,
+	}
+
+	_, err := serviceDiscovery.Run(context.Background())
+
+	assert.Error(t, err, "Expected an error as the writer only writes one file")
+	assert.Equal(t, 0, writer.writes, "Expected nothing to be written")
+}
+
+// cancellingWriter cancels the run as soon as the first shard is written
+type cancellingWriter struct {
+	io.Writer
+	cancel context.CancelFunc
+}
+
+func (w cancellingWriter) WithFilename(filename string) io.Writer {
+	return cancellingWriter{Writer: w.Writer.(filenameWriter).WithFilename(filename), cancel: w.cancel}
+}
+
+func (w cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.Writer.Write(p)
+}
+
+func TestRunWritesEveryShardOnceStarted(t *testing.T) {
+	memoryFS := afero.NewMemMapFs()
+	checks := make([]Healthcheck, 0)
+	for i := 0; i < 20; i++ {
+		checks = append(checks, Healthcheck{URL: fmt.Sprintf("https://service-%d.ft.com/__health", i), IsLive: true})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serviceDiscovery := BizOps{
+		Writer:    cancellingWriter{Writer: NewFileWriter("/etc/prometheus", memoryFS), cancel: cancel},
+		ApiClient: &MockAPIClient{response: newGraphQLResponse(checks)},
+		Shards:    3,
+	}
+
+	_, err := serviceDiscovery.Run(ctx)
+	require.NoError(t, err, "Expected the run to finish once writing had started")
+	for shard := 0; shard < 3; shard++ {
+		exists, _ := afero.Exists(memoryFS, filepath.Join("/etc/prometheus", ShardFilename(shard)))
+		assert.Truef(t, exists, "Expected shard %d to be written", shard)
+	}
+}
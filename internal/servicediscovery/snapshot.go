@@ -0,0 +1,122 @@
+package servicediscovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SnapshotFilename the default name of the snapshot file in the output directory,
+// hidden and without the .json extension so it is never matched by a file_sd glob
+const SnapshotFilename = ".biz-ops-snapshot"
+
+// SnapshotMaxAge how old the snapshot may get while the healthchecks are unchanged before it is saved again,
+// so the data age served after a restart stays close to when biz-ops was last reached
+const SnapshotMaxAge = time.Hour
+
+// Snapshot the healthchecks biz-ops returned in the last successful fetch
+type Snapshot struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	// Healthchecks every field biz-ops returned for each healthcheck
+	Healthchecks []map[string]interface{} `json:"healthchecks"`
+}
+
+func newSnapshot(healthchecks []Healthcheck, fetchedAt time.Time) Snapshot {
+	snapshot := Snapshot{
+		FetchedAt:    fetchedAt,
+		Healthchecks: make([]map[string]interface{}, 0, len(healthchecks)),
+	}
+	for _, healthcheck := range healthchecks {
+		snapshot.Healthchecks = append(snapshot.Healthchecks, healthcheck.fields())
+	}
+	return snapshot
+}
+
+// sameHealthchecks whether both snapshots hold the same healthchecks
+func (snapshot Snapshot) sameHealthchecks(other Snapshot) bool {
+	encoded, err := json.Marshal(snapshot.Healthchecks)
+	if err != nil {
+		return false
+	}
+	otherEncoded, err := json.Marshal(other.Healthchecks)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(encoded, otherEncoded)
+}
+
+// healthchecks returns the healthchecks as if they had just been fetched from biz-ops
+func (snapshot Snapshot) healthchecks() ([]Healthcheck, error) {
+	encoded, err := json.Marshal(snapshot.Healthchecks)
+	if err != nil {
+		return nil, err
+	}
+	var healthchecks []Healthcheck
+	if err := json.Unmarshal(encoded, &healthchecks); err != nil {
+		return nil, err
+	}
+	return healthchecks, nil
+}
+
+// SnapshotFile persists the last known good healthchecks so they survive a restart
+type SnapshotFile struct {
+	Path string
+	fs   afero.Fs
+}
+
+// NewSnapshotFile returns the snapshot at the path, using the OS filesystem if fs is nil
+func NewSnapshotFile(path string, fs afero.Fs) *SnapshotFile {
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+	return &SnapshotFile{Path: path, fs: fs}
+}
+
+// Save atomically replaces the snapshot
+func (file *SnapshotFile) Save(snapshot Snapshot) error {
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(file.fs, file.Path, content)
+}
+
+// Load returns the saved snapshot, or nil if there isn't one
+func (file *SnapshotFile) Load() (*Snapshot, error) {
+	content, err := afero.ReadFile(file.fs, file.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the snapshot %s (%v)", file.Path, err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse the snapshot %s (%v)", file.Path, err)
+	}
+	return &snapshot, nil
+}
+
+// snapshotSource discovers the biz-ops targets from a snapshot rather than the API
+type snapshotSource struct {
+	bizOps   *BizOps
+	snapshot Snapshot
+}
+
+func (source snapshotSource) Name() string {
+	return source.bizOps.Name()
+}
+
+func (source snapshotSource) Discover(ctx context.Context) ([]Target, error) {
+	healthchecks, err := source.snapshot.healthchecks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the snapshot healthchecks (%v)", err)
+	}
+	// the metrics describe what biz-ops last returned, which the snapshot isn't
+	return source.bizOps.healthcheckTargets(healthchecks, false)
+}
@@ -0,0 +1,149 @@
+package servicediscovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSavesSnapshot(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	snapshotFile := NewSnapshotFile("/state/.biz-ops-snapshot", fs)
+	serviceDiscovery := BizOps{
+		Writer: &RecordingWriter{},
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				Healthcheck{URL: "https://url.com", IsLive: true, Systems: []System{System{SystemCode: "system-a"}}},
+			}),
+		},
+		Snapshot: snapshotFile,
+	}
+
+	before := time.Now()
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+
+	snapshot, err := snapshotFile.Load()
+	require.NoError(t, err, "Error not expected")
+	require.NotNil(t, snapshot, "Expected the snapshot to be saved")
+	assert.False(t, snapshot.FetchedAt.Before(before), "Expected the fetch time to be saved")
+	healthchecks, err := snapshot.healthchecks()
+	require.NoError(t, err, "Error not expected")
+	require.Len(t, healthchecks, 1, "Expected the healthchecks to be saved")
+	assert.Equal(t, "https://url.com", healthchecks[0].URL, "Expected the healthcheck to be saved")
+	assert.Equal(t, []System{System{SystemCode: "system-a"}}, healthchecks[0].Systems, "Expected the healthcheck to be saved")
+}
+
+func TestRunOnlySavesChangedSnapshot(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	snapshotFile := NewSnapshotFile("/state/.biz-ops-snapshot", fs)
+	apiClient := &MockAPIClient{
+		response: newGraphQLResponse([]Healthcheck{
+			Healthcheck{URL: "https://url.com", IsLive: true},
+		}),
+	}
+	serviceDiscovery := BizOps{
+		Writer:    &RecordingWriter{},
+		ApiClient: apiClient,
+		Snapshot:  snapshotFile,
+	}
+
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+	first, err := snapshotFile.Load()
+	require.NoError(t, err, "Error not expected")
+
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+	unchanged, err := snapshotFile.Load()
+	require.NoError(t, err, "Error not expected")
+	assert.True(t, first.FetchedAt.Equal(unchanged.FetchedAt), "Expected unchanged healthchecks not to be saved again")
+
+	apiClient.response = newGraphQLResponse([]Healthcheck{
+		Healthcheck{URL: "https://url.com", IsLive: true},
+		Healthcheck{URL: "https://other.com", IsLive: true},
+	})
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+	changed, err := snapshotFile.Load()
+	require.NoError(t, err, "Error not expected")
+	assert.Len(t, changed.Healthchecks, 2, "Expected changed healthchecks to be saved")
+}
+
+func TestRunDoesNotSaveBlockedSnapshot(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	snapshotFile := NewSnapshotFile("/state/.biz-ops-snapshot", fs)
+	writer := &RecordingWriter{content: []byte(`[{"targets": ["https://one.com", "https://two.com", "https://three.com"], "labels": {"observe": "yes"}}]`)}
+	serviceDiscovery := BizOps{
+		Writer: writer,
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				Healthcheck{URL: "https://one.com", IsLive: true},
+			}),
+		},
+		Guard:    BlastRadiusGuard{MaxDropPercent: 50},
+		Snapshot: snapshotFile,
+	}
+
+	assert.Error(t, serviceDiscovery.Write(), "Expected the truncated response to be blocked")
+	assert.Equal(t, 0, writer.writes, "Expected nothing to be written")
+
+	snapshot, err := snapshotFile.Load()
+	require.NoError(t, err, "Error not expected")
+	assert.Nil(t, snapshot, "Expected the blocked response not to be saved as the snapshot")
+}
+
+func TestWarmStart(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	snapshotFile := NewSnapshotFile("/state/.biz-ops-snapshot", fs)
+	fetchedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, snapshotFile.Save(newSnapshot([]Healthcheck{
+		Healthcheck{URL: "https://url.com", IsLive: true},
+		Healthcheck{URL: "https://down.com", IsLive: false},
+		Healthcheck{URL: "not a url", IsLive: true},
+	}, fetchedAt)))
+	rule, err := ParseFilterRule("exclude:live=false")
+	require.NoError(t, err)
+
+	writer := MockWriter{}
+	targets := NewTargetStore()
+	serviceDiscovery := BizOps{
+		Writer:    &writer,
+		ApiClient: &MockAPIClient{err: errors.New("biz-ops API call failed")},
+		Targets:   targets,
+		Snapshot:  snapshotFile,
+		Filters:   []FilterRule{rule},
+	}
+
+	InvalidURLs.Set(3)
+	filtered := testutil.ToFloat64(FilteredTargets.WithLabelValues("exclude:live=false"))
+	assert.True(t, serviceDiscovery.WarmStart(context.Background()), "Expected the snapshot to be used")
+	assert.Equal(t, float64(3), testutil.ToFloat64(InvalidURLs), "Expected the snapshot not to be counted as a run")
+	assert.Equal(t, filtered, testutil.ToFloat64(FilteredTargets.WithLabelValues("exclude:live=false")), "Expected the snapshot not to be counted as a run")
+
+	content, _, ok := targets.Targets()
+	require.True(t, ok, "Expected the targets to be stored")
+	assert.JSONEq(t, `[{"targets": ["https://url.com"], "labels": {"observe": "yes"}}]`, string(content), "Expected the targets from the snapshot")
+	assert.True(t, fetchedAt.Equal(targets.FetchedAt()), "Expected the snapshot fetch time to be stored")
+	assert.InDelta(t, time.Hour.Seconds(), testutil.ToFloat64(NewDataAgeGauge(targets)), 5, "Expected the age of the snapshot to be reported")
+	writer.AssertNotCalled(t, "Write")
+
+	// biz-ops being unavailable keeps the snapshot targets in place
+	assert.Error(t, serviceDiscovery.Write(), "Expected the run to fail")
+	stored, _, _ := targets.Targets()
+	assert.Equal(t, content, stored, "Expected the snapshot targets to still be served")
+}
+
+func TestWarmStartWithoutSnapshot(t *testing.T) {
+	serviceDiscovery := BizOps{
+		Targets:  NewTargetStore(),
+		Snapshot: NewSnapshotFile("/missing", afero.NewMemMapFs()),
+	}
+
+	assert.False(t, serviceDiscovery.WarmStart(context.Background()), "Expected no warm start without a snapshot")
+	_, _, ok := serviceDiscovery.Targets.Targets()
+	assert.False(t, ok, "Expected nothing to be stored")
+	assert.Equal(t, float64(0), testutil.ToFloat64(NewDataAgeGauge(serviceDiscovery.Targets)), "Expected no age without data")
+}
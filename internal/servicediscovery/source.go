@@ -0,0 +1,122 @@
+package servicediscovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// SourceTargets the number of targets each source discovered in the last run
+var SourceTargets = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "service_discovery_source_targets",
+		Help: "Number of targets discovered by each source in the last service discovery run",
+	},
+	[]string{"source"},
+)
+
+// Target a target to be scraped and the labels it is written with
+type Target struct {
+	URL    string
+	Labels map[string]string
+}
+
+// Source discovers targets from a backend such as biz-ops or a file
+type Source interface {
+	// Name identifies the source in logs and metrics
+	Name() string
+	// Discover returns every target the source knows about
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// SourceError is returned when one of the additional sources could not discover its targets
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source %s failed (%v)", e.Source, e.Err)
+}
+
+// sources returns every source in order of precedence, biz-ops first
+func (bizOps *BizOps) sources() []Source {
+	return append([]Source{bizOps}, bizOps.Sources...)
+}
+
+// discoverAll discovers the targets from every source and merges them.
+// Any failed source fails the run so its targets are never removed by mistake.
+func (bizOps *BizOps) discoverAll(ctx context.Context, sources []Source) ([]Target, error) {
+	results := make([][]Target, len(sources))
+	for i, source := range sources {
+		targets, err := source.Discover(ctx)
+		if err != nil {
+			if source.Name() == bizOps.Name() || ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, &SourceError{Source: source.Name(), Err: err}
+		}
+		SourceTargets.WithLabelValues(source.Name()).Set(float64(len(targets)))
+		results[i] = targets
+	}
+	return mergeTargets(sources, results), nil
+}
+
+// mergeTargets combines the targets from each source. A target found by more than one source
+// is written with only the labels from the source with the highest precedence.
+func mergeTargets(sources []Source, results [][]Target) []Target {
+	owners := map[string]int{}
+	for i, targets := range results {
+		for _, target := range targets {
+			if _, owned := owners[target.URL]; !owned {
+				owners[target.URL] = i
+			}
+		}
+	}
+
+	merged := make([]Target, 0)
+	for i, targets := range results {
+		for _, target := range targets {
+			owner := owners[target.URL]
+			if owner != i {
+				log.WithFields(log.Fields{
+					"event":  "TARGET_OVERRIDDEN",
+					"url":    target.URL,
+					"source": sources[i].Name(),
+					"winner": sources[owner].Name(),
+				}).Debug("A target was found by a source with higher precedence, its labels are used instead.")
+				continue
+			}
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
+
+// groupTargets groups the targets by their labels, in the order each label set was first seen
+func groupTargets(targets []Target) []prometheusConfiguration {
+	labelsToUrls := map[string][]string{}
+	labelsKeys := make([]labels, 0)
+	for _, target := range targets {
+		targetLabels := labels(target.Labels)
+		if targetLabels == nil {
+			targetLabels = labels{}
+		}
+		key := targetLabels.key()
+		if _, seen := labelsToUrls[key]; !seen {
+			labelsKeys = append(labelsKeys, targetLabels)
+		}
+		labelsToUrls[key] = append(labelsToUrls[key], target.URL)
+	}
+
+	configuration := make([]prometheusConfiguration, 0, len(labelsKeys))
+	for _, l := range labelsKeys {
+		configuration = append(configuration, prometheusConfiguration{
+			Labels:  l,
+			Targets: labelsToUrls[l.key()],
+		})
+	}
+	return configuration
+}
@@ -0,0 +1,96 @@
+package servicediscovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type MockSource struct {
+	name    string
+	targets []Target
+	err     error
+}
+
+func (s MockSource) Name() string {
+	return s.name
+}
+
+func (s MockSource) Discover(ctx context.Context) ([]Target, error) {
+	return s.targets, s.err
+}
+
+func TestRunMergesSources(t *testing.T) {
+	writer := RecordingWriter{}
+	serviceDiscovery := BizOps{
+		Writer: &writer,
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				Healthcheck{URL: "https://url.com", IsLive: true},
+			}),
+		},
+		Sources: []Source{
+			MockSource{name: "static", targets: []Target{
+				{URL: "https://url.com", Labels: map[string]string{"observe": "no"}},
+				{URL: "https://third-party.com", Labels: map[string]string{"observe": "yes", "system": "third-party"}},
+			}},
+			MockSource{name: "file_sd", targets: []Target{
+				{URL: "https://third-party.com", Labels: map[string]string{"observe": "no"}},
+				{URL: "https://other.com", Labels: map[string]string{"observe": "no"}},
+			}},
+		},
+	}
+
+	require.NoError(t, serviceDiscovery.Write(), "Error not expected")
+
+	expected := `[
+  {
+    "targets": [
+      "https://other.com"
+    ],
+    "labels": {
+      "observe": "no"
+    }
+  },
+  {
+    "targets": [
+      "https://url.com"
+    ],
+    "labels": {
+      "observe": "yes"
+    }
+  },
+  {
+    "targets": [
+      "https://third-party.com"
+    ],
+    "labels": {
+      "observe": "yes",
+      "system": "third-party"
+    }
+  }
+]`
+	assert.Equal(t, expected, string(writer.content), "Expected the targets from the source with the highest precedence to be written")
+}
+
+func TestRunFailsWhenASourceFails(t *testing.T) {
+	writer := RecordingWriter{}
+	sourceErr := errors.New("file not found")
+	serviceDiscovery := BizOps{
+		Writer: &writer,
+		ApiClient: &MockAPIClient{
+			response: newGraphQLResponse([]Healthcheck{
+				Healthcheck{URL: "https://url.com", IsLive: true},
+			}),
+		},
+		Sources: []Source{MockSource{name: "static", err: sourceErr}},
+	}
+
+	err := serviceDiscovery.Write()
+
+	assert.Equal(t, &SourceError{Source: "static", Err: sourceErr}, err, "Expected the source error to be returned")
+	assert.Equal(t, 0, writer.writes, "Expected nothing to be written")
+}
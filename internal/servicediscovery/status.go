@@ -0,0 +1,63 @@
+package servicediscovery
+
+import (
+	"sync"
+	"time"
+)
+
+// RunStatus records the outcome of service discovery runs, for reporting health
+type RunStatus struct {
+	mutex        sync.RWMutex
+	lastRun      time.Time
+	lastErr      error
+	lastFetchErr error
+	lastSuccess  time.Time
+}
+
+// NewRunStatus returns a RunStatus with no runs recorded
+func NewRunStatus() *RunStatus {
+	return &RunStatus{}
+}
+
+// Record stores the outcome of a run and counts it in the run metrics
+func (status *RunStatus) Record(err error) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	status.lastRun = time.Now()
+	status.lastErr = err
+	status.lastFetchErr = nil
+	if fetchErr, ok := err.(*FetchError); ok {
+		status.lastFetchErr = fetchErr
+	}
+
+	Runs.Inc()
+	if err != nil {
+		Failures.Inc()
+		return
+	}
+	status.lastSuccess = status.lastRun
+	Writes.Inc()
+	LastSuccessTimestamp.Set(float64(status.lastRun.UnixNano()) / 1e9)
+}
+
+// LastRun returns when the last run finished and its error, the time is zero if nothing has run
+func (status *RunStatus) LastRun() (time.Time, error) {
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+	return status.lastRun, status.lastErr
+}
+
+// LastFetchErr returns the error from fetching the healthchecks in the last run, if it failed
+func (status *RunStatus) LastFetchErr() error {
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+	return status.lastFetchErr
+}
+
+// LastSuccess returns when the last successful run finished, zero if there hasn't been one
+func (status *RunStatus) LastSuccess() time.Time {
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+	return status.lastSuccess
+}
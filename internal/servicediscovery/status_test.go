@@ -0,0 +1,40 @@
+package servicediscovery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStatusRecord(t *testing.T) {
+	runsBefore := testutil.ToFloat64(Runs)
+	writesBefore := testutil.ToFloat64(Writes)
+	failuresBefore := testutil.ToFloat64(Failures)
+
+	status := NewRunStatus()
+	lastRun, err := status.LastRun()
+	assert.True(t, lastRun.IsZero(), "Expected no run to be recorded")
+	assert.NoError(t, err, "Expected no error to be recorded")
+
+	status.Record(nil)
+	lastSuccess := status.LastSuccess()
+	assert.False(t, lastSuccess.IsZero(), "Expected the successful run to be recorded")
+	assert.NoError(t, status.LastFetchErr(), "Expected no fetch error after a successful run")
+
+	fetchErr := &FetchError{Err: errors.New("biz-ops API call failed")}
+	status.Record(fetchErr)
+	_, err = status.LastRun()
+	assert.Equal(t, fetchErr, err, "Expected the failed run to be recorded")
+	assert.Equal(t, fetchErr, status.LastFetchErr(), "Expected the fetch error to be recorded")
+	assert.Equal(t, lastSuccess, status.LastSuccess(), "Expected the last success to be kept")
+
+	status.Record(errors.New("Write failed"))
+	assert.NoError(t, status.LastFetchErr(), "Expected biz-ops to be reachable when the write failed")
+
+	assert.Equal(t, runsBefore+3, testutil.ToFloat64(Runs), "Expected every run to be counted")
+	assert.Equal(t, writesBefore+1, testutil.ToFloat64(Writes), "Expected only the successful run to be counted as a write")
+	assert.Equal(t, failuresBefore+2, testutil.ToFloat64(Failures), "Expected the failed runs to be counted")
+	assert.Equal(t, float64(lastSuccess.UnixNano())/1e9, testutil.ToFloat64(LastSuccessTimestamp), "Expected the last success to be exported")
+}
@@ -0,0 +1,65 @@
+package servicediscovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TargetStore holds the configuration from the last successful run in memory so it can be served over HTTP
+type TargetStore struct {
+	mutex     sync.RWMutex
+	content   []byte
+	etag      string
+	fetchedAt time.Time
+}
+
+// NewTargetStore returns an empty TargetStore
+func NewTargetStore() *TargetStore {
+	return &TargetStore{}
+}
+
+// Set replaces the stored configuration, recording when the data it was built from was fetched
+func (store *TargetStore) Set(content []byte, fetchedAt time.Time) {
+	hash := sha256.Sum256(content)
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.content = content
+	store.etag = `"` + hex.EncodeToString(hash[:]) + `"`
+	store.fetchedAt = fetchedAt
+}
+
+// FetchedAt returns when the data behind the stored configuration was fetched, zero if nothing is stored
+func (store *TargetStore) FetchedAt() time.Time {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.fetchedAt
+}
+
+// NewDataAgeGauge reports how old the data behind the stored configuration is, 0 until there is any
+func NewDataAgeGauge(store *TargetStore) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "service_discovery_data_age_seconds",
+			Help: "Seconds since the data behind the served targets was fetched",
+		},
+		func() float64 {
+			fetchedAt := store.FetchedAt()
+			if fetchedAt.IsZero() {
+				return 0
+			}
+			return time.Since(fetchedAt).Seconds()
+		},
+	)
+}
+
+// Targets returns the stored configuration in the Prometheus file_sd/http_sd JSON format along
+// with its ETag, ok is false until a configuration has been stored.
+func (store *TargetStore) Targets() (content []byte, etag string, ok bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.content, store.etag, store.content != nil
+}
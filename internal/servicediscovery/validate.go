@@ -0,0 +1,69 @@
+package servicediscovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateConfiguration lints the content of a file_sd file, returning every problem found
+func ValidateConfiguration(content []byte) []error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	var configuration []prometheusConfiguration
+	if err := decoder.Decode(&configuration); err != nil {
+		return []error{fmt.Errorf("not a list of target groups (%v)", err)}
+	}
+	if decoder.More() {
+		return []error{fmt.Errorf("unexpected content after the list of target groups")}
+	}
+
+	problems := make([]error, 0)
+	if len(configuration) == 0 {
+		problems = append(problems, fmt.Errorf("there are no target groups"))
+	}
+	for index, group := range configuration {
+		if len(group.Targets) == 0 {
+			problems = append(problems, fmt.Errorf("group %d has no targets", index))
+		}
+		seen := map[string]bool{}
+		for _, target := range group.Targets {
+			switch {
+			case strings.TrimSpace(target) == "":
+				problems = append(problems, fmt.Errorf("group %d has an empty target", index))
+			case seen[target]:
+				problems = append(problems, fmt.Errorf("group %d lists target %q more than once", index, target))
+			}
+			seen[target] = true
+		}
+		for name, value := range group.Labels {
+			if !labelNamePattern.MatchString(name) {
+				problems = append(problems, fmt.Errorf("group %d has an invalid label name %q", index, name))
+			}
+			if !utf8.ValidString(value) {
+				problems = append(problems, fmt.Errorf("group %d label %q is not valid UTF-8", index, name))
+			}
+		}
+	}
+	return problems
+}
+
+// ValidateEncodedConfiguration lints a file_sd file in the encoder's format, returning every problem found
+func ValidateEncodedConfiguration(content []byte, encoder Encoder) []error {
+	if _, ok := encoder.(JSONEncoder); ok {
+		return ValidateConfiguration(content)
+	}
+
+	var configuration []prometheusConfiguration
+	if err := encoder.Decode(content, &configuration); err != nil {
+		return []error{fmt.Errorf("not a list of target groups (%v)", err)}
+	}
+	asJSON, err := json.Marshal(configuration)
+	if err != nil {
+		return []error{err}
+	}
+	return ValidateConfiguration(asJSON)
+}
@@ -0,0 +1,54 @@
+package servicediscovery
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfiguration(t *testing.T) {
+	testCases := map[string]struct {
+		content          string
+		expectedProblems []string
+	}{
+		"valid configuration should have no problems": {
+			content:          `[{"targets": ["https://one.ft.com/__health", "https://two.ft.com/__health"], "labels": {"observe": "yes"}}]`,
+			expectedProblems: []string{},
+		},
+		"invalid JSON should be reported": {
+			content:          `[{"targets": [`,
+			expectedProblems: []string{"not a list of target groups (unexpected EOF)"},
+		},
+		"unknown fields should be reported": {
+			content:          `[{"targets": ["https://one.ft.com/__health"], "label": {"observe": "yes"}}]`,
+			expectedProblems: []string{`not a list of target groups (json: unknown field "label")`},
+		},
+		"no groups should be reported": {
+			content:          `[]`,
+			expectedProblems: []string{"there are no target groups"},
+		},
+		"every problem in the groups should be reported": {
+			content: `[
+				{"targets": [], "labels": {}},
+				{"targets": ["https://one.ft.com/__health", "", "https://one.ft.com/__health"], "labels": {"1bad": "x"}}
+			]`,
+			expectedProblems: []string{
+				"group 0 has no targets",
+				"group 1 has an empty target",
+				`group 1 lists target "https://one.ft.com/__health" more than once`,
+				`group 1 has an invalid label name "1bad"`,
+			},
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(fmt.Sprintf("Running test case: %s", name), func(t *testing.T) {
+			problems := make([]string, 0)
+			for _, err := range ValidateConfiguration([]byte(test.content)) {
+				problems = append(problems, err.Error())
+			}
+			assert.Equal(t, test.expectedProblems, problems, "Problems were not as expected")
+		})
+	}
+}